@@ -80,6 +80,17 @@ func (m *messageQueue) Receive() (bus.Message, error) {
 		return nil, err
 	}
 
+	// the header fills in metadata the event was sent without, but never
+	// overwrites what the event already carries
+	if len(msg.header) > 0 && msg.event.Metadata == nil {
+		msg.event.Metadata = make(eventstore.Metadata, len(msg.header))
+	}
+	for key, value := range msg.header {
+		if _, ok := msg.event.Metadata[key]; !ok {
+			msg.event.Metadata[key] = value
+		}
+	}
+
 	return msg, nil
 }
 
@@ -110,6 +121,7 @@ func (m *messageQueue) Send(event eventstore.Event) error {
 
 	raw := json.RawMessage(eventData)
 	env.Body = &raw
+	env.Header = bus.Header(event.Metadata.Copy())
 	msg, err := json.Marshal(env)
 	if err != nil {
 		return err
@@ -1,39 +1,143 @@
 package ironmq
 
-import "testing"
-
-func skipTestSend(t *testing.T) {
-	// bus := New("dev-queue")
-
-	// msg, err := bus.Receive()
-	// if err != nil {
-	// 	t.Fatal(err)
-	// }
-	//
-	// t.Log(msg.Header())
-	// t.Log(msg.Event())
-	// err = bus.Ack(msg)
-
-	// data, err := json.Marshal(struct {
-	// 	Key   string
-	// 	Value string
-	// }{
-	// 	Key:   "foo",
-	// 	Value: "bar",
-	// })
-	// if err != nil {
-	// 	t.Fatal(err)
-	// }
-	// raw := json.RawMessage(data)
-	//
-	// err = bus.Send(eventstore.Event{
-	// 	ID:      "poll.1",
-	// 	Version: 3,
-	// 	Type:    "TestEvent",
-	// 	Data:    &raw,
-	// })
-
-	// if err != nil {
-	// 	t.Fatal(err)
-	// }
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/ebittleman/voting/bus"
+	"github.com/ebittleman/voting/eventstore"
+	"github.com/iron-io/iron_go3/mq"
+)
+
+func TestSendAndReceive(t *testing.T) {
+	queue := new(fakeQueue)
+	messageQueue := &messageQueue{queue: queue}
+
+	data := json.RawMessage(`{"id":"poll.1"}`)
+	sent := eventstore.Event{
+		ID:       "poll.1",
+		Version:  3,
+		Type:     "TestEvent",
+		Data:     &data,
+		Metadata: eventstore.NewMetadata("request", "command", "voter"),
+	}
+
+	if err := messageQueue.Send(sent); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := messageQueue.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := msg.Event()
+	if received.ID != sent.ID ||
+		received.Version != sent.Version ||
+		received.Type != sent.Type ||
+		string(*received.Data) != string(data) {
+		t.Fatalf("Expected: %v, Got: %v", sent, received)
+	}
+
+	metadata := received.Metadata
+	if metadata.CorrelationID() != "request" ||
+		metadata.CausationID() != "command" ||
+		metadata.Actor() != "voter" {
+		t.Fatalf("Unexpected Metadata: %v", metadata)
+	}
+
+	if header := msg.Header(); header[eventstore.CausationIDKey] != "command" {
+		t.Fatalf("Unexpected Header: %v", header)
+	}
+
+	if msg, err = messageQueue.Receive(); err != nil || msg != nil {
+		t.Fatalf("Expected: an empty queue, Got: %v, %v", msg, err)
+	}
+}
+
+func TestReceiveKeepsEventMetadata(t *testing.T) {
+	queue := new(fakeQueue)
+	messageQueue := &messageQueue{queue: queue}
+
+	body, _ := json.Marshal(eventstore.Event{
+		ID:       "poll.1",
+		Version:  1,
+		Type:     "TestEvent",
+		Metadata: eventstore.Metadata{eventstore.CausationIDKey: "event"},
+	})
+	raw := json.RawMessage(body)
+	envelope, _ := json.Marshal(bus.Envelope{
+		Header: bus.Header{
+			eventstore.CausationIDKey:   "header",
+			eventstore.CorrelationIDKey: "request",
+		},
+		Body: &raw,
+	})
+	queue.PushString(string(envelope))
+
+	msg, err := messageQueue.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := msg.Event().Metadata
+	if metadata.CausationID() != "event" {
+		t.Fatalf("Expected: %s, Got: %s", "event", metadata.CausationID())
+	}
+	if metadata.CorrelationID() != "request" {
+		t.Fatalf("Expected: %s, Got: %s", "request", metadata.CorrelationID())
+	}
+}
+
+// fakeQueue keeps pushed messages in memory, handing them out oldest first.
+type fakeQueue struct {
+	messages []mq.Message
+}
+
+func (f *fakeQueue) PushString(body string) (string, error) {
+	ids, err := f.PushStrings(body)
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+func (f *fakeQueue) PushStrings(bodies ...string) ([]string, error) {
+	var ids []string
+	for _, body := range bodies {
+		id := strconv.Itoa(len(f.messages) + 1)
+		f.messages = append(f.messages, mq.Message{Id: id, Body: body})
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (f *fakeQueue) Peek() ([]mq.Message, error) {
+	return f.PeekN(1)
+}
+
+func (f *fakeQueue) PeekN(n int) ([]mq.Message, error) {
+	if n > len(f.messages) {
+		n = len(f.messages)
+	}
+	return append([]mq.Message{}, f.messages[:n]...), nil
+}
+
+func (f *fakeQueue) Reserve() (*mq.Message, error) {
+	msgs, err := f.ReserveN(1)
+	if err != nil || len(msgs) < 1 {
+		return nil, err
+	}
+	return &msgs[0], nil
+}
+
+func (f *fakeQueue) ReserveN(n int) ([]mq.Message, error) {
+	msgs, _ := f.PeekN(n)
+	f.messages = f.messages[len(msgs):]
+	return msgs, nil
+}
+
+func (f *fakeQueue) LongPoll(n, timeout, wait int, delete bool) ([]mq.Message, error) {
+	return f.ReserveN(n)
 }
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"flag"
 	"log"
 
 	"github.com/ebittleman/voting/voting/app"
 )
 
 func main() {
+	jsonDir := flag.String(
+		"json",
+		"",
+		"read events from the json database in this directory instead of couchdb",
+	)
+	flag.Parse()
+
 	votingWorker := app.NewVotingWorker(
 		app.VotingWorkerConfig{
 			IronQueueName: "dev-queue",
-			JSONDir:       "./.data",
+			JSONDir:       *jsonDir,
 		},
 	)
 	defer votingWorker.Close()
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/ebittleman/voting/bus"
 	"github.com/ebittleman/voting/bus/ironmq"
@@ -53,18 +57,27 @@ func run() int {
 	args := os.Args
 	id := args[1]
 	action := args[2]
+
+	// every event emitted by this run shares a correlation id and is
+	// attributed to the user running it. The command id stays the same across
+	// retries so a write that timed out but went through isn't repeated.
+	metadata := eventstore.NewMetadata(
+		uuid.NewV4().String(),
+		uuid.NewV4().String(),
+		os.Getenv("USER"),
+	)
 	switch action {
 	case "open":
 		// initialize the OpenPoll command and reuse the the same id to open our
 		// newly created poll
-		openPoll := commands.NewOpenPoll(
+		openPoll := commands.NewRetry(commands.NewOpenPoll(
 			eventStore,
 			eventManager,
 			id,
-		)
+		), commands.DefaultAttempts)
 
 		// open the poll
-		err = openPoll.Run()
+		err = commands.WithMetadata(openPoll, metadata).Run()
 		if err != nil {
 			log.Println("Fatal: ", err)
 			return 1
@@ -72,18 +85,51 @@ func run() int {
 	case "close":
 		// initialize the OpenPoll command and reuse the the same id to open our
 		// newly created poll
-		closePoll := commands.NewClosePoll(
+		closePoll := commands.NewRetry(commands.NewClosePoll(
 			eventStore,
 			eventManager,
 			id,
-		)
+		), commands.DefaultAttempts)
 
 		// close the poll
-		err = closePoll.Run()
+		err = commands.WithMetadata(closePoll, metadata).Run()
+		if err != nil {
+			log.Println("Fatal: ", err)
+			return 1
+		}
+	case "cast":
+		ballot, ballotErr := newBallot(eventStore, id, args[3:])
+		if ballotErr != nil {
+			log.Println("Fatal: ", ballotErr)
+			return 1
+		}
+
+		// initialize the CastBallot command with one choice per issue on the
+		// poll
+		castBallot := commands.NewRetry(commands.NewCastBallot(
+			eventStore,
+			eventManager,
+			id,
+			ballot,
+		), commands.DefaultAttempts)
+
+		// cast the ballot
+		err = commands.WithMetadata(castBallot, metadata).Run()
 		if err != nil {
 			log.Println("Fatal: ", err)
 			return 1
 		}
+	case "at":
+		// print the poll as it was at a version or an RFC3339 timestamp
+		if len(args) < 4 {
+			log.Println("Usage: voting <id> at <version|timestamp>")
+			return 1
+		}
+
+		if err = printPollAt(eventStore, id, args[3]); err != nil {
+			log.Println("Fatal: ", err)
+			return 1
+		}
 	default:
 		log.Println("Unkown Action: ", action)
 		return 1
@@ -92,6 +138,95 @@ func run() int {
 	return 0
 }
 
+// printPollAt writes the state of a poll as of a version, or as of a point in
+// time given in RFC3339, to stdout.
+func printPollAt(
+	eventStore eventstore.EventStore,
+	id string,
+	at string,
+) error {
+	var (
+		version   int64
+		timestamp int64
+	)
+
+	if v, err := strconv.ParseInt(at, 10, 64); err == nil {
+		version = v
+	} else if t, err := time.Parse(time.RFC3339, at); err == nil {
+		timestamp = t.UTC().Unix()
+	} else {
+		return fmt.Errorf("Expected a version or an RFC3339 timestamp, Got: %s", at)
+	}
+
+	events, err := eventstore.QueryUntil(eventStore, id, version, timestamp)
+	if err != nil {
+		return err
+	} else if len(events) < 1 {
+		return commands.ErrPollNotFound
+	}
+
+	var poll model.Poll
+	if version != 0 {
+		poll, err = model.LoadPollAtVersion(id, events, version)
+	} else {
+		poll, err = model.LoadPollAtTime(id, events, timestamp)
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(poll, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(data))
+	return nil
+}
+
+// newBallot builds a ballot from a list of choice indexes, one for each issue on
+// the poll in the order they were appended.
+func newBallot(
+	eventStore eventstore.EventStore,
+	id string,
+	choices []string,
+) (model.Ballot, error) {
+	events, err := eventStore.Query(id)
+	if err != nil {
+		return nil, err
+	} else if len(events) < 1 {
+		return nil, commands.ErrPollNotFound
+	}
+
+	poll, err := model.LoadPoll(id, events)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(choices) != len(poll.Issues) {
+		return nil, fmt.Errorf(
+			"Expected: %d choice(s), Got: %d choice(s)",
+			len(poll.Issues),
+			len(choices),
+		)
+	}
+
+	var ballot model.Ballot
+	for i, issue := range poll.Issues {
+		choice, err := strconv.Atoi(choices[i])
+		if err != nil {
+			return nil, err
+		}
+
+		ballot = append(ballot, model.Selection{
+			Issue:  issue,
+			Choice: choice,
+		})
+	}
+
+	return ballot, nil
+}
+
 func newPoll(
 	eventStore eventstore.EventStore,
 	eventManager eventmanager.EventManager,
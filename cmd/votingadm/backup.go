@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	jsondb "github.com/ebittleman/voting/database/json"
+	jsonEventStore "github.com/ebittleman/voting/eventstore/json"
+	jsonViews "github.com/ebittleman/voting/views/json"
+)
+
+// backup copies the events, snapshots and views tables of the json database in
+// jsonDir to a single archive at file. The database is opened read only so a
+// backup can be taken while another process is writing to it, which waits to
+// write anything more until the tables are copied. Returns the exit code for
+// the process.
+func backup(jsonDir, file string) int {
+	conn, err := jsondb.OpenReadOnly(jsonDir)
+	if err != nil {
+		log.Println("Fatal: ", err)
+		return 1
+	}
+	defer conn.Close()
+
+	if _, err = jsonEventStore.New(conn); err != nil {
+		log.Println("Fatal: ", err)
+		return 1
+	}
+
+	if _, err = jsonViews.NewStore(conn); err != nil {
+		log.Println("Fatal: ", err)
+		return 1
+	}
+
+	out, err := os.Create(file)
+	if err != nil {
+		log.Println("Fatal: ", err)
+		return 1
+	}
+
+	manifest, err := conn.Backup(out)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		log.Println("Fatal: ", err)
+		return 1
+	}
+
+	for _, table := range manifest.Tables {
+		log.Println("Info: Backed up ", table.Records, " record(s) from ", table.Name)
+	}
+
+	return 0
+}
+
+// restore writes the tables in the archive at file to jsonDir, which must be
+// empty. Returns the exit code for the process.
+func restore(jsonDir, file string) int {
+	in, err := os.Open(file)
+	if err != nil {
+		log.Println("Fatal: ", err)
+		return 1
+	}
+	defer in.Close()
+
+	manifest, err := jsondb.Restore(in, jsonDir)
+	if err != nil {
+		log.Println("Fatal: ", err)
+		return 1
+	}
+
+	for _, table := range manifest.Tables {
+		log.Println("Info: Restored ", table.Records, " record(s) to ", table.Name)
+	}
+
+	return 0
+}
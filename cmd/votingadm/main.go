@@ -1,16 +1,67 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	jsondb "github.com/ebittleman/voting/database/json"
+	"github.com/ebittleman/voting/eventstore"
+	couchdbEventStore "github.com/ebittleman/voting/eventstore/couchdb"
+	jsonEventStore "github.com/ebittleman/voting/eventstore/json"
 	couchdb "github.com/fjl/go-couchdb"
 )
 
 func main() {
+	jsonDir := flag.String(
+		"json",
+		"",
+		"use the json database in this directory instead of couchdb",
+	)
+	anchorFile := flag.String(
+		"anchor",
+		"",
+		"check verify against the stream heads recorded in this file, and update it",
+	)
+	flag.Parse()
+
+	switch command := flag.Arg(0); command {
+	case "", "install":
+		install()
+	case "verify":
+		eventStore, err := openEventStore(*jsonDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if code := verify(eventStore, *anchorFile); code != 0 {
+			os.Exit(code)
+		}
+	case "backup", "restore":
+		if *jsonDir == "" || flag.Arg(1) == "" {
+			log.Fatal("Usage: votingadm -json dir ", command, " file")
+		}
+
+		run := backup
+		if command == "restore" {
+			run = restore
+		}
+
+		if code := run(*jsonDir, flag.Arg(1)); code != 0 {
+			os.Exit(code)
+		}
+	default:
+		log.Fatal("Unknown Command: ", command)
+	}
+}
+
+// install creates the couchdb databases, installs the views they need and gives
+// events stored before events had positions one.
+func install() {
 	name := "events"
 	client, err := client()
 	if err != nil {
@@ -29,7 +80,35 @@ func main() {
 
 	if err = installView(db); err != nil {
 		log.Println(err)
+		return
+	}
+
+	count, err := couchdbEventStore.BackfillPositions(client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Info: Positioned ", count, " event(s) stored without one")
+}
+
+// openEventStore opens the json event store in jsonDir, or the couchdb one at
+// COUCHDB_URL when jsonDir is empty. The json database is opened read only, so
+// it can be checked while another process is writing to it.
+func openEventStore(jsonDir string) (eventstore.EventStore, error) {
+	if jsonDir != "" {
+		conn, err := jsondb.OpenReadOnly(jsonDir)
+		if err != nil {
+			return nil, err
+		}
+
+		return jsonEventStore.New(conn)
+	}
+
+	client, err := client()
+	if err != nil {
+		return nil, err
 	}
+
+	return couchdbEventStore.New(client)
 }
 
 func client() (*couchdb.Client, error) {
@@ -50,8 +129,15 @@ func createDatabase(client *couchdb.Client, name string) (*couchdb.DB, error) {
 	return client.EnsureDB(name)
 }
 
+// installView creates the indexes design doc, or updates it so that new views
+// are picked up by existing databases.
 func installView(db *couchdb.DB) error {
-	rev, err := db.Put("_design/indexes", indexDesignDoc, "")
+	rev, err := db.Rev("_design/indexes")
+	if err != nil && !couchdb.NotFound(err) {
+		return err
+	}
+
+	rev, err = db.Put("_design/indexes", indexDesignDoc, rev)
 	if err != nil {
 		return err
 	}
@@ -69,10 +155,41 @@ var indexDesignDoc = struct {
 }{
 	Views: map[string]map[string]string{
 		"events": map[string]string{
-			"map": "function(doc) {\n    emit([doc.id, doc.version], null)\n}",
+			"map": eventView("[e.id, e.version]"),
 		},
 		"by_type": map[string]string{
-			"map": "function(doc) {\n  emit([doc.type, doc.id, doc.version], null)\n}",
+			"map": eventView("[e.type, e.id, e.version]"),
+		},
+		"by_position": map[string]string{
+			"map": eventView("[e.position]"),
+		},
+		"without_position": map[string]string{
+			"map": "function(doc) {\n  if (!doc.type || doc.position) return\n  emit([doc.timestamp, doc.id, doc.version], null)\n}",
+		},
+		"by_type_and_time": map[string]string{
+			"map": eventView("[e.type, e.timestamp, e.position]"),
+		},
+		"by_event_id": map[string]string{
+			"map": eventView("[e.event_id]", "e.event_id"),
 		},
 	},
 }
+
+// eventView is the map function of a view emitting key for every event, both
+// those stored as documents of their own, emitted with a null value, and those
+// in a commit document, emitted with their index in it. Events are only
+// emitted when every condition holds for them.
+func eventView(key string, conditions ...string) string {
+	body := "emit(" + key + ", i)"
+	if len(conditions) > 0 {
+		body = "if (" + strings.Join(conditions, " && ") + ") " + body
+	}
+
+	return "function(doc) {\n" +
+		"  var emitEvent = function(e, i) {\n" +
+		"    " + body + "\n" +
+		"  }\n" +
+		"  if (doc.type) emitEvent(doc, null)\n" +
+		"  if (doc.events) doc.events.forEach(emitEvent)\n" +
+		"}"
+}
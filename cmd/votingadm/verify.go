@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/ebittleman/voting/eventstore"
+)
+
+// verify walks every event in the store, in the order they were appended, and
+// reports every event that breaks its stream's hash chain. When anchorFile is
+// given, every stream recorded in it must still reach its recorded head, and
+// once nothing is broken it is updated to the heads of the streams now in the
+// store. Returns the exit code for the process.
+func verify(eventStore eventstore.EventStore, anchorFile string) int {
+	anchor, err := readAnchor(anchorFile)
+	if err != nil {
+		log.Println("Fatal: ", err)
+		return 1
+	}
+
+	var (
+		verifier       = eventstore.NewChainVerifier(anchor)
+		events, breaks int
+	)
+
+	it := eventStore.IterateAll(0, 0)
+	defer it.Close()
+
+	for it.Next() {
+		events++
+		if err := verifier.Check(it.Event()); err != nil {
+			breaks++
+			log.Println("Error: ", err)
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		log.Println("Fatal: ", err)
+		return 1
+	}
+
+	for _, err := range verifier.Finish() {
+		breaks++
+		log.Println("Error: ", err)
+	}
+
+	log.Println("Info: Verified ", events, " event(s), found ", breaks, " break(s)")
+	if legacy := verifier.Legacy(); legacy > 0 {
+		log.Println("Info: ", legacy, " event(s) were stored before events were hashed")
+	}
+	if breaks > 0 {
+		return 2
+	}
+
+	if err := writeAnchor(anchorFile, verifier.Anchor()); err != nil {
+		log.Println("Fatal: ", err)
+		return 1
+	}
+
+	return 0
+}
+
+// readAnchor reads the anchor at file, if there is one yet.
+func readAnchor(file string) (eventstore.Anchor, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var anchor eventstore.Anchor
+	if err = json.Unmarshal(data, &anchor); err != nil {
+		return nil, err
+	}
+
+	return anchor, nil
+}
+
+// writeAnchor replaces the anchor at file, leaving the old one in place if it
+// can't be written in full.
+func writeAnchor(file string, anchor eventstore.Anchor) error {
+	if file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
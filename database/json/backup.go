@@ -0,0 +1,312 @@
+package json
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDirectoryNotEmpty returned by Restore when the target directory
+	// already has files in it.
+	ErrDirectoryNotEmpty = errors.New("Directory Not Empty")
+	// ErrInvalidBackup returned by Restore when an archive is missing its
+	// manifest or has files the manifest doesn't describe.
+	ErrInvalidBackup = errors.New("Invalid Backup")
+	// ErrUnfinishedTransaction returned by Backup when a commit was
+	// interrupted, and the directory has to be opened for writing to finish it
+	// before it can be backed up.
+	ErrUnfinishedTransaction = errors.New("Unfinished Transaction")
+)
+
+// manifestName is the archive entry describing the rest of a backup.
+const manifestName = "manifest.json"
+
+// Manifest describes the table files in a backup.
+type Manifest struct {
+	Created int64           `json:"created"`
+	Tables  []ManifestTable `json:"tables"`
+}
+
+// ManifestTable describes a table file in a backup.
+type ManifestTable struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+// Backup writes every registered table to a single gzipped tar archive,
+// followed by a manifest of the tables and their checksums. The tables are
+// copied as they were at a single point in time. On a connection opened for
+// writing nothing is put to them while the backup is taken. A read only
+// connection copies the table files instead, and holds off the process writing
+// to them until it is done, so none of its commits is copied in part.
+func (c *Connection) Backup(w io.Writer) (Manifest, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	var names []string
+	for name := range c.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	read := func(name string) ([]byte, int, error) {
+		data, records := scanAll(c.tables[name].Scan())
+		return data, records, nil
+	}
+
+	if c.readOnly {
+		unlock, err := lockWrites(c.path)
+		if err != nil {
+			return Manifest{}, err
+		}
+		defer unlock()
+
+		_, err = os.Stat(path.Join(c.path, transactionFileName))
+		if err == nil {
+			return Manifest{}, ErrUnfinishedTransaction
+		} else if !os.IsNotExist(err) {
+			return Manifest{}, err
+		}
+
+		read = c.readFile
+	} else {
+		// journals are always locked in the same order, as they are by commits
+		for _, name := range names {
+			j := c.journals[name]
+			j.Lock()
+			defer j.Unlock()
+		}
+	}
+
+	manifest := Manifest{Created: time.Now().UTC().Unix()}
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+
+	for _, name := range names {
+		data, records, err := read(name)
+		if err != nil {
+			return manifest, err
+		}
+		sum := sha256.Sum256(data)
+
+		if err = writeEntry(archive, name+".json", data); err != nil {
+			return manifest, err
+		}
+
+		manifest.Tables = append(manifest.Tables, ManifestTable{
+			Name:    name,
+			Records: records,
+			Size:    int64(len(data)),
+			SHA256:  hex.EncodeToString(sum[:]),
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	if err = writeEntry(archive, manifestName, data); err != nil {
+		return manifest, err
+	}
+
+	if err = archive.Close(); err != nil {
+		return manifest, err
+	}
+
+	return manifest, compressed.Close()
+}
+
+// Restore writes the tables in a backup to dir, which must be empty, after
+// checking them against the backup's manifest. The whole backup is checked
+// before dir is locked or anything is written to it, and nothing is left in dir
+// when writing it fails.
+func Restore(r io.Reader, dir string) (manifest Manifest, err error) {
+	if infos, err := ioutil.ReadDir(dir); err != nil {
+		return manifest, err
+	} else if len(infos) > 0 {
+		return manifest, ErrDirectoryNotEmpty
+	}
+
+	manifest, tables, err := readBackup(r)
+	if err != nil {
+		return manifest, err
+	}
+
+	_, unlock, err := lockDir(dir)
+	if err != nil {
+		return manifest, err
+	}
+
+	var written []string
+	defer func() {
+		unlock()
+		if err == nil {
+			return
+		}
+
+		written = append(
+			written,
+			path.Join(dir, lockFileName),
+			path.Join(dir, writeLockFileName),
+		)
+		for _, f := range written {
+			os.Remove(f)
+		}
+	}()
+
+	for _, table := range manifest.Tables {
+		f := path.Join(dir, table.Name+".json")
+		written = append(written, f)
+
+		if err = writeFile(f, tables[table.Name]); err != nil {
+			return manifest, err
+		}
+	}
+
+	return manifest, syncDir(dir)
+}
+
+// readBackup reads a backup's manifest and table files, and checks every table
+// against the manifest.
+func readBackup(r io.Reader) (manifest Manifest, tables map[string][]byte, err error) {
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, nil, err
+	}
+	defer compressed.Close()
+
+	var (
+		archive = tar.NewReader(compressed)
+		found   bool
+	)
+	tables = make(map[string][]byte)
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return manifest, nil, err
+		}
+
+		if header.Name == manifestName {
+			if err = json.NewDecoder(archive).Decode(&manifest); err != nil {
+				return manifest, nil, err
+			}
+			found = true
+			continue
+		}
+
+		if path.Base(header.Name) != header.Name ||
+			!strings.HasSuffix(header.Name, ".json") {
+			return manifest, nil, ErrInvalidBackup
+		}
+
+		data, err := ioutil.ReadAll(archive)
+		if err != nil {
+			return manifest, nil, err
+		}
+		tables[strings.TrimSuffix(header.Name, ".json")] = data
+	}
+
+	if !found || len(manifest.Tables) != len(tables) {
+		return manifest, nil, ErrInvalidBackup
+	}
+
+	for _, table := range manifest.Tables {
+		data, ok := tables[table.Name]
+		if !ok {
+			return manifest, nil, ErrInvalidBackup
+		}
+
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != table.SHA256 ||
+			int64(len(data)) != table.Size {
+			return manifest, nil, fmt.Errorf(
+				"Checksum mismatch for table %s", table.Name,
+			)
+		}
+
+		if records := bytes.Count(data, []byte{'\n'}); records != table.Records {
+			return manifest, nil, fmt.Errorf(
+				"Expected %d record(s) in table %s, Got: %d",
+				table.Records,
+				table.Name,
+				records,
+			)
+		}
+	}
+
+	return manifest, tables, nil
+}
+
+// readFile returns the complete records in a table's file, one per line and
+// uncompressed, and how many there are.
+func (c *Connection) readFile(name string) ([]byte, int, error) {
+	file, err := c.fileProvider(path.Join(c.path, name+".json"))
+	if _, ok := err.(*os.PathError); ok {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	if closer, ok := file.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	records, errCh := make(chan json.RawMessage), make(chan error, 1)
+	go func() {
+		defer close(records)
+		_, _, err := readRecords(file, records, false)
+		errCh <- err
+	}()
+
+	data, count := scanAll(records)
+	return data, count, <-errCh
+}
+
+// scanAll returns records, one per line, and how many there are.
+func scanAll(records chan json.RawMessage) ([]byte, int) {
+	var (
+		buffer = new(bytes.Buffer)
+		count  int
+	)
+
+	for record := range records {
+		buffer.Write(record)
+		buffer.WriteByte('\n')
+		count++
+	}
+
+	return buffer.Bytes(), count
+}
+
+func writeEntry(archive *tar.Writer, name string, data []byte) error {
+	if err := archive.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	_, err := archive.Write(data)
+	return err
+}
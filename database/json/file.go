@@ -0,0 +1,206 @@
+package json
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempSuffix is added to a table file's name while it is being rewritten.
+const tempSuffix = ".tmp"
+
+// atomicFile writes to a temporary file beside path, which only replaces the
+// file at path once it has been synced and closed, so a crash part way through
+// a flush leaves the previous file untouched.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+func createFile(f string) (io.Writer, error) {
+	file, err := os.Create(f + tempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Debug: Create", filepath.Base(f))
+
+	return &atomicFile{File: file, path: f}, nil
+}
+
+// Close syncs the temporary file and renames it over path.
+func (a *atomicFile) Close() error {
+	if err := a.File.Sync(); err != nil {
+		a.Abort()
+		return err
+	}
+
+	if err := a.File.Close(); err != nil {
+		os.Remove(a.Name())
+		return err
+	}
+
+	if err := os.Rename(a.Name(), a.path); err != nil {
+		os.Remove(a.Name())
+		return err
+	}
+
+	return syncDir(filepath.Dir(a.path))
+}
+
+// Abort throws away everything written, leaving path as it was.
+func (a *atomicFile) Abort() error {
+	a.File.Close()
+	return os.Remove(a.Name())
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
+
+// abort closes a file that was not completely written, without letting it
+// replace the table's previous file when it knows how.
+func abort(file io.Writer) {
+	if aborter, ok := file.(interface {
+		Abort() error
+	}); ok {
+		aborter.Abort()
+		return
+	}
+
+	if closer, ok := file.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// recoverFiles cleans up after flushes that never finished. A temporary file
+// is dropped when the table file it was replacing still exists, as that file
+// is intact. Otherwise it is the only copy of the table, so its complete lines
+// are kept and it is renamed into place.
+func recoverFiles(dir string) error {
+	temps, err := filepath.Glob(filepath.Join(dir, "*.json"+tempSuffix))
+	if err != nil {
+		return err
+	}
+
+	for _, temp := range temps {
+		f := strings.TrimSuffix(temp, tempSuffix)
+		if _, err = os.Stat(f); err == nil {
+			log.Println("Warn: Discarding unfinished flush: ", temp)
+			if err = os.Remove(temp); err != nil {
+				return err
+			}
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		log.Println("Warn: Recovering unfinished flush: ", temp)
+		if err = recoverFile(temp, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recoverFile drops a partly written last line from temp and renames it to f.
+// What can still be decompressed from a compressed file is kept uncompressed.
+func recoverFile(temp, f string) error {
+	data, err := ioutil.ReadFile(temp)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(data, gzipMagic) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			data = nil
+		} else {
+			data, _ = ioutil.ReadAll(reader)
+		}
+	}
+
+	if err = writeFile(temp, data[:bytes.LastIndexByte(data, '\n')+1]); err != nil {
+		return err
+	}
+
+	if err = os.Rename(temp, f); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(f))
+}
+
+// recoverTail cuts a record a crash left partly appended off the end of f, so
+// the next append doesn't run into it.
+func recoverTail(f string) error {
+	file, err := os.OpenFile(f, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := info.Size()
+	if size < 1 {
+		return nil
+	}
+
+	first, last := make([]byte, len(gzipMagic)), make([]byte, 1)
+	if _, err = file.ReadAt(last, size-1); err != nil {
+		return err
+	}
+	file.ReadAt(first, 0)
+	if last[0] == '\n' && !bytes.Equal(first, gzipMagic) {
+		return nil
+	}
+
+	records := make(chan json.RawMessage)
+	go drain(records)
+	_, read, err := readRecords(io.NewSectionReader(file, 0, size), records, false)
+	close(records)
+	if err != nil || read == size {
+		return err
+	}
+
+	log.Println("Warn: Dropping partly appended record: ", f)
+	if err = file.Truncate(read); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// writeFile replaces the contents of f with data and syncs it.
+func writeFile(f string, data []byte) error {
+	file, err := os.Create(f)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
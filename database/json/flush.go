@@ -0,0 +1,65 @@
+package json
+
+import (
+	"log"
+	"time"
+)
+
+// flusher is a background loop flushing a connection's dirty tables.
+type flusher struct {
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// SetFlushInterval flushes the tables written to since their last flush every
+// interval, in the background, until the connection is closed. An interval of
+// zero stops flushing.
+func (c *Connection) SetFlushInterval(interval time.Duration) {
+	c.stopFlushing()
+	if interval <= 0 {
+		return
+	}
+
+	f := new(flusher)
+	f.done = make(chan struct{})
+	f.stopped = make(chan struct{})
+
+	c.Lock()
+	c.flusher = f
+	c.Unlock()
+
+	go c.flushEvery(interval, f)
+}
+
+func (c *Connection) flushEvery(interval time.Duration, f *flusher) {
+	defer close(f.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Flush(); err != nil {
+				log.Println("Error: Couldn't write db to disk: ", err)
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// stopFlushing stops the background flush and waits for one in progress.
+func (c *Connection) stopFlushing() {
+	c.Lock()
+	f := c.flusher
+	c.flusher = nil
+	c.Unlock()
+
+	if f == nil {
+		return
+	}
+
+	close(f.done)
+	<-f.stopped
+}
@@ -0,0 +1,85 @@
+package json
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// gzipMagic starts every gzip compressed file.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// SetGzip compresses table files with gzip from their next flush or
+// compaction on. Compressed files are recognized when tables are registered,
+// whatever this is set to, so it can be changed at any time.
+func (c *Connection) SetGzip(compress bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.gzip = compress
+}
+
+// gzipFile compresses everything written to a file from the connection's
+// fileCreator.
+type gzipFile struct {
+	*gzip.Writer
+	file io.Writer
+}
+
+func newGzipFile(file io.Writer) *gzipFile {
+	return &gzipFile{Writer: gzip.NewWriter(file), file: file}
+}
+
+// Close finishes the compressed stream and closes the file.
+func (g *gzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		abort(g.file)
+		return err
+	}
+
+	if closer, ok := g.file.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// Abort abandons the file.
+func (g *gzipFile) Abort() error {
+	abort(g.file)
+	return nil
+}
+
+// compress data as a gzip member of its own, which can be appended to a
+// compressed file and read back with the rest of it.
+func compress(data []byte) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	writer := gzip.NewWriter(buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// isGzipFile reports whether f was written compressed.
+func isGzipFile(f string) (bool, error) {
+	file, err := os.Open(f)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(gzipMagic))
+	if _, err = io.ReadFull(file, magic); err != nil {
+		return false, nil
+	}
+
+	return bytes.Equal(magic, gzipMagic), nil
+}
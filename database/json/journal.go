@@ -0,0 +1,199 @@
+package json
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+// journal serializes writes to a table and keeps track of its file: whether
+// it is compressed, how much of it the table has seen and, on an append only
+// connection, an open handle for appending to it. It also remembers whether
+// the table has changes that are not on disk yet.
+type journal struct {
+	file   io.Writer
+	offset int64
+	info   os.FileInfo
+	dirty  bool
+	gzip   bool
+	sync.Mutex
+}
+
+// SetAppendOnly makes each Put append its records to the table's file and sync
+// them to disk before returning, instead of waiting for the next Flush. Flush
+// then only has deletions left to write, and Compact rewrites the files to drop
+// records later ones replaced.
+func (c *Connection) SetAppendOnly(appendOnly bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.appendOnly = appendOnly
+}
+
+// SetFileAppender gives some customizability in how we append data
+func (c *Connection) SetFileAppender(fileAppender func(f string) (io.Writer, error)) {
+	c.fileAppender = fileAppender
+}
+
+// Put passes values to the named table's Put. Every value is marshaled before
+// any of them is stored, and on an append only connection they are written to
+// the table's file in a single synced write before the table sees them.
+func (c *Connection) Put(name string, values ...interface{}) error {
+	c.Lock()
+	table, ok := c.tables[name]
+	j := c.journals[name]
+	appendOnly, readOnly := c.appendOnly, c.readOnly
+	c.Unlock()
+
+	if readOnly {
+		return ErrReadOnly
+	}
+
+	if !ok {
+		return ErrTableNotFound
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	records := make([]json.RawMessage, len(values))
+	for i, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		records[i] = data
+	}
+
+	if appendOnly {
+		if err := c.append(name, j, joinRecords(records)); err != nil {
+			return err
+		}
+	}
+
+	if !appendOnly {
+		j.dirty = true
+	}
+
+	for _, v := range values {
+		if err := table.Put(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the record with key from the named table. The deletion is
+// written to disk by the next Flush, on append only connections too.
+func (c *Connection) Delete(name string, key string) error {
+	c.Lock()
+	table, ok := c.tables[name]
+	j := c.journals[name]
+	readOnly := c.readOnly
+	c.Unlock()
+
+	if readOnly {
+		return ErrReadOnly
+	}
+
+	if !ok {
+		return ErrTableNotFound
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if err := table.Delete(key); err != nil {
+		return err
+	}
+
+	j.dirty = true
+	return nil
+}
+
+// append writes data to the end of the table's file and syncs it. Must be
+// called with the journal locked.
+func (c *Connection) append(name string, j *journal, data []byte) error {
+	if j.gzip {
+		compressed, err := compress(data)
+		if err != nil {
+			return err
+		}
+		data = compressed
+	}
+
+	if err := c.beginWrite(); err != nil {
+		return err
+	}
+	defer c.endWrite()
+
+	if j.file == nil {
+		file, err := c.fileAppender(path.Join(c.path, name+".json"))
+		if err != nil {
+			return err
+		}
+		j.file = file
+	}
+
+	if err := write(j.file, data); err != nil {
+		return err
+	}
+
+	if syncer, ok := j.file.(interface {
+		Sync() error
+	}); ok {
+		if err := syncer.Sync(); err != nil {
+			return err
+		}
+	}
+
+	c.wrote(name, j, data)
+	return nil
+}
+
+// close releases the table's file, which is reopened by the next append. Must
+// be called with the journal locked.
+func (j *journal) close() error {
+	if j.file == nil {
+		return nil
+	}
+
+	file := j.file
+	j.file = nil
+	if closer, ok := file.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// write appends data to file. What was written of it is cut off again when the
+// write fails, where file allows it, so the next append starts a fresh line.
+func write(file io.Writer, data []byte) error {
+	truncater, ok := file.(interface {
+		Stat() (os.FileInfo, error)
+		Truncate(int64) error
+	})
+	if !ok {
+		_, err := file.Write(data)
+		return err
+	}
+
+	info, err := truncater.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		truncater.Truncate(info.Size())
+		return err
+	}
+
+	return nil
+}
+
+func appendFile(f string) (io.Writer, error) {
+	return os.OpenFile(f, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
@@ -2,10 +2,13 @@ package json
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
@@ -20,12 +23,23 @@ var (
 	// ErrTableExists returned when attempting to register a table that already
 	// exists.
 	ErrTableExists = errors.New("Table Already Exists")
+	// ErrTableNotFound returned when writing to a table that is not registered.
+	ErrTableNotFound = errors.New("Table Not Found")
+	// ErrRecordNotFound returned by a table's Get when it has no record with
+	// the key.
+	ErrRecordNotFound = errors.New("Record Not Found")
 )
 
-// Table implementation for marshaling and unmarshaling records
+// Table implementation for marshaling and unmarshaling records. Each record
+// has a key of the table's choosing.
 type Table interface {
 	Scan() chan json.RawMessage
+	// ScanPrefix is Scan limited to the records with keys starting with prefix.
+	ScanPrefix(prefix string) chan json.RawMessage
+	// Get returns ErrRecordNotFound when there is no record with the key.
+	Get(key string) (json.RawMessage, error)
 	Put(interface{}) error
+	Delete(key string) error
 	Load(chan json.RawMessage) error
 }
 
@@ -33,13 +47,34 @@ type Table interface {
 type Connection struct {
 	path         string
 	tables       map[string]Table
+	journals     map[string]*journal
+	appendOnly   bool
+	readOnly     bool
+	gzip         bool
+	lock         *dirLock
+	unlock       func() error
+	flusher      *flusher
 	fileProvider func(string) (io.Reader, error)
 	fileCreator  func(string) (io.Writer, error)
+	fileAppender func(string) (io.Writer, error)
 	sync.Mutex
 }
 
-// Open creates a new json db connection from the passed file
+// Open creates a new json db connection from the passed file. The directory is
+// locked for writing until the connection is closed, and ErrLocked is returned
+// if another process already has it.
 func Open(path string) (*Connection, error) {
+	return open(path, false)
+}
+
+// OpenReadOnly creates a json db connection that loads tables from the passed
+// file but never writes to it. No lock is taken, so it can be used alongside
+// the process that has the directory open for writing.
+func OpenReadOnly(path string) (*Connection, error) {
+	return open(path, true)
+}
+
+func open(path string, readOnly bool) (*Connection, error) {
 	if stat, err := os.Stat(path); err != nil {
 		return nil, err
 	} else if !stat.IsDir() {
@@ -47,14 +82,26 @@ func Open(path string) (*Connection, error) {
 	}
 
 	connection := new(Connection)
+	connection.readOnly = readOnly
+
+	if !readOnly {
+		lock, unlock, err := lockDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = recoverDir(lock, path); err != nil {
+			unlock()
+			return nil, err
+		}
+		connection.lock, connection.unlock = lock, unlock
+	}
+
 	connection.path = path
 	connection.tables = make(map[string]Table)
-	connection.fileCreator = func(f string) (io.Writer, error) {
-		file, err := os.Create(f)
-		stat, _ := file.Stat()
-		log.Println("Debug: Create", stat.Name())
-		return file, err
-	}
+	connection.journals = make(map[string]*journal)
+	connection.fileAppender = appendFile
+	connection.fileCreator = createFile
 	connection.fileProvider = func(f string) (io.Reader, error) {
 		file, err := os.Open(f)
 		if stat, _ := file.Stat(); stat != nil {
@@ -66,6 +113,20 @@ func Open(path string) (*Connection, error) {
 	return connection, nil
 }
 
+// recoverDir cleans up after flushes and commits a crash interrupted.
+func recoverDir(lock *dirLock, dir string) error {
+	if err := lock.beginWrite(); err != nil {
+		return err
+	}
+	defer lock.endWrite()
+
+	if err := recoverFiles(dir); err != nil {
+		return err
+	}
+
+	return recoverTransaction(dir)
+}
+
 // SetFileProvider gives some customizability in how we load data
 func (c *Connection) SetFileProvider(fileCreator func(f string) (io.Reader, error)) {
 	c.fileProvider = fileCreator
@@ -82,7 +143,9 @@ func (c *Connection) GetFileCreator() func(f string) (io.Writer, error) {
 	return c.fileCreator
 }
 
-// RegisterTable adds a table implementation the the database.
+// RegisterTable adds a table implementation the the database. On a connection
+// opened for writing, a record a crash left partly appended to the table's file
+// is dropped first.
 func (c *Connection) RegisterTable(name string, table Table) error {
 	c.Lock()
 	defer c.Unlock()
@@ -90,19 +153,50 @@ func (c *Connection) RegisterTable(name string, table Table) error {
 		return ErrTableExists
 	}
 
+	if !c.readOnly {
+		if err := c.beginWrite(); err != nil {
+			return err
+		}
+		err := recoverTail(path.Join(c.path, name+".json"))
+		c.endWrite()
+		if err != nil {
+			return err
+		}
+	}
+
+	j := new(journal)
+	// a table starts out in the connection's format until its file says
+	// otherwise
+	j.gzip = c.gzip
+	if err := c.load(name, table, j); err != nil {
+		return err
+	}
+
+	c.tables[name] = table
+	c.journals[name] = j
+	return nil
+}
+
+// load passes the records in a table's file after the journal's offset to the
+// table, and moves the offset past the last complete record.
+func (c *Connection) load(name string, table Table, j *journal) error {
+	f := path.Join(c.path, name+".json")
+	// the file is looked at before it is read, so one replaced part way
+	// through is noticed by the next Reload
+	info, _ := os.Stat(f)
+
+	var (
+		read    int64
+		gzipped = j.gzip
+	)
+
 	records, errCh := make(chan json.RawMessage), make(chan error, 1)
 	go func() {
 		defer close(records)
 		defer close(errCh)
 
-		var (
-			file     io.Reader
-			line     []byte
-			isPrefix bool
-			err      error
-		)
-
-		if file, err = c.fileProvider(path.Join(c.path, name+".json")); err != nil {
+		file, err := c.fileProvider(f)
+		if err != nil {
 			if _, ok := err.(*os.PathError); ok {
 				return
 			}
@@ -114,35 +208,19 @@ func (c *Connection) RegisterTable(name string, table Table) error {
 			defer closer.Close()
 		}
 
-		buffer := bufio.NewReader(file)
-		for err == nil {
-
-			fullLine := make([]byte, 0)
-			line, isPrefix, err = buffer.ReadLine()
-			for ; isPrefix; line, isPrefix, err = buffer.ReadLine() {
-				if err != nil {
-					break
-				}
-				fullLine = append(fullLine, line...)
-			}
-
-			if err != nil && err != io.EOF {
-				log.Println("Error Reading json table file: ", err)
-				errCh <- err
-				return
-			}
-
-			fullLine = append(fullLine, line...)
-
-			if len(fullLine) < 1 {
-				continue
-			}
+		if err = skip(file, j.offset); err != nil {
+			errCh <- err
+			return
+		}
 
-			records <- json.RawMessage(fullLine)
+		gzipped, read, err = readRecords(file, records, gzipped)
+		if err != nil {
+			errCh <- err
 		}
 	}()
 
 	if err := table.Load(records); err != nil {
+		drain(records)
 		return err
 	}
 
@@ -150,57 +228,232 @@ func (c *Connection) RegisterTable(name string, table Table) error {
 		return err
 	}
 
-	c.tables[name] = table
+	j.offset += read
+	j.info = info
+	j.gzip = gzipped
 	return nil
 }
 
+// readRecords sends every complete line read from file to records,
+// decompressing them first when the file turns out to be compressed. A last
+// line without its newline, or a last compressed member that was cut short, is
+// still being appended and is left for the next read. Reports whether the file
+// was compressed, or returns gzipped when there was nothing to read, and how
+// many bytes of it were read.
+func readRecords(
+	file io.Reader,
+	records chan json.RawMessage,
+	gzipped bool,
+) (bool, int64, error) {
+	counter := &countingReader{Reader: file}
+	buffer := bufio.NewReader(counter)
+	if magic, _ := buffer.Peek(len(gzipMagic)); len(magic) > 0 {
+		gzipped = bytes.Equal(magic, gzipMagic)
+	}
+
+	if !gzipped {
+		read, err := readLines(buffer, records)
+		return gzipped, read, err
+	}
+
+	reader, err := gzip.NewReader(buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return gzipped, 0, nil
+	} else if err != nil {
+		return gzipped, 0, err
+	}
+	defer reader.Close()
+
+	// each append is a gzip member of its own, so the file is read a member
+	// at a time and only members that were completely written count as read
+	var read int64
+	for {
+		reader.Multistream(false)
+		data, err := ioutil.ReadAll(reader)
+		if err == io.ErrUnexpectedEOF {
+			return gzipped, read, nil
+		} else if err != nil {
+			log.Println("Error Reading json table file: ", err)
+			return gzipped, read, err
+		}
+
+		if _, err = readLines(bytes.NewReader(data), records); err != nil {
+			return gzipped, read, err
+		}
+		read = counter.count - int64(buffer.Buffered())
+
+		if err = reader.Reset(buffer); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return gzipped, read, nil
+		} else if err != nil {
+			return gzipped, read, err
+		}
+	}
+}
+
+// readLines sends every line ending in a newline to records and returns how
+// many bytes those lines took up.
+func readLines(file io.Reader, records chan json.RawMessage) (int64, error) {
+	var (
+		buffer = bufio.NewReader(file)
+		read   int64
+	)
+
+	for {
+		line, err := buffer.ReadBytes('\n')
+		if err == io.EOF {
+			return read, nil
+		} else if err != nil {
+			log.Println("Error Reading json table file: ", err)
+			return read, err
+		}
+		read += int64(len(line))
+
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) < 1 {
+			continue
+		}
+
+		records <- json.RawMessage(line)
+	}
+}
+
 func (c *Connection) UnregisterTable(name string) {
 	c.Lock()
 	defer c.Unlock()
+	if j, ok := c.journals[name]; ok {
+		j.Lock()
+		if err := j.close(); err != nil {
+			log.Println("Warn: Closing json table file: ", err)
+		}
+		j.Unlock()
+	}
 	delete(c.tables, name)
+	delete(c.journals, name)
 }
 
-// Flush writes the tables to disk
+// Flush writes the tables that were put to or deleted from since they were
+// last written to disk. On an append only connection puts are already on disk,
+// so only tables with deletions are written.
 func (c *Connection) Flush() error {
 	c.Lock()
 	defer c.Unlock()
+	if c.readOnly {
+		return nil
+	}
+
+	return c.rewrite(false)
+}
+
+// Compact rewrites every table's file from its current records.
+func (c *Connection) Compact() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	return c.rewrite(true)
+}
+
+// rewrite writes the dirty tables, or all of them, to disk. Must be called
+// with the connection locked.
+func (c *Connection) rewrite(all bool) error {
+	for name, table := range c.tables {
+		j := c.journals[name]
+		j.Lock()
+		var err error
+		if all || j.dirty {
+			if err = c.compact(name, table, j); err == nil {
+				j.dirty = false
+			}
+		}
+		j.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compact rewrites a table's file. The file is only replaced once every record
+// has been written. Must be called with the journal locked.
+func (c *Connection) compact(name string, table Table, j *journal) error {
+	if err := j.close(); err != nil {
+		return err
+	}
+
+	if err := c.beginWrite(); err != nil {
+		return err
+	}
+	defer c.endWrite()
+
 	var (
 		file io.Writer
 		data []byte
 		err  error
 	)
-	for name, table := range c.tables {
-		if file, err = c.fileCreator(path.Join(c.path, name+".json")); err != nil {
-			return nil
-		}
+	if file, err = c.fileCreator(path.Join(c.path, name+".json")); err != nil {
+		return err
+	}
 
-		for record := range table.Scan() {
-			if data, err = record.MarshalJSON(); err != nil {
-				if closer, ok := file.(io.Closer); ok {
-					closer.Close()
-				}
-				return err
-			}
+	if c.gzip {
+		file = newGzipFile(file)
+	}
 
-			if _, err = fmt.Fprintln(file, string(data)); err != nil {
-				if closer, ok := file.(io.Closer); ok {
-					closer.Close()
-				}
-				return err
-			}
+	records := table.Scan()
+	for record := range records {
+		if data, err = record.MarshalJSON(); err != nil {
+			drain(records)
+			abort(file)
+			return err
 		}
 
-		if closer, ok := file.(io.Closer); ok {
-			if err = closer.Close(); err != nil {
-				return err
-			}
+		if _, err = fmt.Fprintln(file, string(data)); err != nil {
+			drain(records)
+			abort(file)
+			return err
 		}
 	}
 
+	if closer, ok := file.(io.Closer); ok {
+		if err = closer.Close(); err != nil {
+			return err
+		}
+	}
+
+	j.gzip = c.gzip
+	c.rewrote(name, j)
 	return nil
 }
 
-// Close flushes write buffer to disk and closes the file.
+// drain unblocks a table's Scan when its records are no longer wanted.
+func drain(records chan json.RawMessage) {
+	for range records {
+	}
+}
+
+// Close flushes write buffer to disk, closes the files and releases the
+// directory lock.
 func (c *Connection) Close() error {
-	return c.Flush()
+	c.stopFlushing()
+	err := c.Flush()
+
+	c.Lock()
+	defer c.Unlock()
+	for _, j := range c.journals {
+		j.Lock()
+		if closeErr := j.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		j.Unlock()
+	}
+
+	if c.unlock != nil {
+		if unlockErr := c.unlock(); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}
+
+	return err
 }
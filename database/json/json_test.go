@@ -1,12 +1,19 @@
 package json
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestOpenWithNonExistantPath(t *testing.T) {
@@ -113,6 +120,795 @@ func TestRegisterTableSuccessWithLoading(t *testing.T) {
 	}
 }
 
+func TestAppendOnlyPut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetAppendOnly(true)
+
+	table := new(recordTable)
+	if err = conn.RegisterTable("test", table); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = conn.Put("test", "one", "two"); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Put("test", "three"); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Put("missing", "four"); err != ErrTableNotFound {
+		t.Fatalf("Expected: %v, Got: %v", ErrTableNotFound, err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, "test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\"one\"\n\"two\"\n\"three\"\n"
+	if string(data) != expected {
+		t.Fatalf("Expected: %q, Got: %q", expected, string(data))
+	}
+
+	table.records = table.records[1:]
+	if err = conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ = ioutil.ReadFile(path.Join(dir, "test.json")); string(data) != expected {
+		t.Fatalf("Expected Flush to leave the file alone, Got: %q", string(data))
+	}
+
+	if err = conn.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Put("test", "four"); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _ = Open(dir)
+	reopened := new(recordTable)
+	if err = conn.RegisterTable("test", reopened); err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.records) != 3 {
+		t.Fatalf("Expected: 3 record(s), Got: %d record(s)", len(reopened.records))
+	}
+}
+
+func TestRegisterTableDropsPartlyAppendedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := path.Join(dir, "test.json")
+	if err = writeFile(f, []byte("\"one\"\n\"tw")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetAppendOnly(true)
+
+	if err = conn.RegisterTable("test", new(recordTable)); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Put("test", "two"); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(f)
+	if string(data) != "\"one\"\n\"two\"\n" {
+		t.Fatalf("Expected: [one two], Got: %q", data)
+	}
+}
+
+func TestFlushReportsCreateErrors(t *testing.T) {
+	conn, err := Open(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = conn.RegisterTable("test", new(recordTable)); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Put("test", "one"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetFileCreator(func(_ string) (io.Writer, error) {
+		return nil, fmt.Errorf("Unknown, But Expected Test Error")
+	})
+
+	if err = conn.Flush(); err == nil {
+		t.Fatal("Expected an Error")
+	}
+}
+
+func TestOpenRecoversUnfinishedFlushes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"kept.json":              "\"old\"\n",
+		"kept.json" + tempSuffix: "\"new\"\n\"ne",
+		"lost.json" + tempSuffix: "\"one\"\n\"tw",
+	}
+	for name, data := range files {
+		if err = ioutil.WriteFile(path.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"kept.json": "\"old\"\n",
+		"lost.json": "\"one\"\n",
+	}
+	infos, _ := ioutil.ReadDir(dir)
+	if len(infos) != len(expected)+2 {
+		t.Fatalf("Expected: %d file(s) and the lock files, Got: %d file(s)",
+			len(expected), len(infos))
+	}
+	for name, data := range expected {
+		actual, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != data {
+			t.Fatalf("Expected: %q, Got: %q", data, string(actual))
+		}
+	}
+}
+
+func TestFlushReplacesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := new(recordTable)
+	if err = conn.RegisterTable("test", table); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Put("test", "one"); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, "test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "\"one\"\n" {
+		t.Fatalf("Expected: %q, Got: %q", "\"one\"\n", string(data))
+	}
+	if _, err = os.Stat(path.Join(dir, "test.json"+tempSuffix)); !os.IsNotExist(err) {
+		t.Fatal("Expected the temporary file to be gone")
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	conn, err := OpenReadOnly(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err = conn.RegisterTable("test", new(recordTable)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = conn.Put("test", "one"); err != ErrReadOnly {
+		t.Fatalf("Expected: %v, Got: %v", ErrReadOnly, err)
+	}
+	if err = conn.Compact(); err != ErrReadOnly {
+		t.Fatalf("Expected: %v, Got: %v", ErrReadOnly, err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	views, checkpoints := new(recordTable), new(recordTable)
+	if err = conn.RegisterTable("views", views); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.RegisterTable("checkpoints", checkpoints); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, _ := conn.Begin()
+	tx.Put("views", "view")
+	tx.Put("checkpoints", 1)
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != ErrTransactionDone {
+		t.Fatalf("Expected: %v, Got: %v", ErrTransactionDone, err)
+	}
+
+	tx, _ = conn.Begin()
+	tx.Put("views", "view")
+	tx.Put("checkpoints", 2)
+	tx.Put("missing", 3)
+	if err = tx.Commit(); err != ErrTableNotFound {
+		t.Fatalf("Expected: %v, Got: %v", ErrTableNotFound, err)
+	}
+
+	tx, _ = conn.Begin()
+	tx.Put("views", "view")
+	tx.Put("checkpoints", 4)
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(views.records) != 1 || len(checkpoints.records) != 1 {
+		t.Fatalf("Expected: 1/1 record(s), Got: %d/%d record(s)",
+			len(views.records), len(checkpoints.records))
+	}
+
+	for name, expected := range map[string]string{
+		"views.json":       "\"view\"\n",
+		"checkpoints.json": "4\n",
+	} {
+		data, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Expected: %q, Got: %q", expected, string(data))
+		}
+	}
+
+	if _, err = os.Stat(path.Join(dir, transactionFileName)); !os.IsNotExist(err) {
+		t.Fatal("Expected the transaction log to be gone")
+	}
+}
+
+func TestOpenFinishesInterruptedTransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		// the commit got as far as part of the views record
+		"views.json": "\"old\"\n\"ne",
+		transactionFileName: `{"tables": [` +
+			`{"name": "checkpoints", "offset": 0, "records": [2]},` +
+			`{"name": "views", "offset": 6, "records": ["new"]}]}`,
+	}
+	for name, data := range files {
+		if err = ioutil.WriteFile(path.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	for name, expected := range map[string]string{
+		"views.json":       "\"old\"\n\"new\"\n",
+		"checkpoints.json": "2\n",
+	} {
+		data, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Expected: %q, Got: %q", expected, string(data))
+		}
+	}
+}
+
+func TestFlushOnlyDirtyTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := make(chan string, 10)
+	fileCreator := conn.GetFileCreator()
+	conn.SetFileCreator(func(f string) (io.Writer, error) {
+		created <- path.Base(f)
+		return fileCreator(f)
+	})
+
+	for _, name := range []string{"clean", "dirty"} {
+		if err = conn.RegisterTable(name, new(recordTable)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn.SetFlushInterval(time.Millisecond)
+	if err = conn.Put("dirty", "one"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case name := <-created:
+		if name != "dirty.json" {
+			t.Fatalf("Expected: dirty.json, Got: %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a background flush")
+	}
+
+	if err = conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(created) != 0 {
+		t.Fatalf("Expected no more flushes, Got: %s", <-created)
+	}
+}
+
+func TestGzipTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetGzip(true)
+
+	if err = conn.RegisterTable("test", new(recordTable)); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Put("test", "one"); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// appends keep the file compressed whatever the connection is set to
+	conn.SetGzip(false)
+	conn.SetAppendOnly(true)
+	if err = conn.Put("test", "two"); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, "test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, gzipMagic) {
+		t.Fatalf("Expected a gzip file, Got: %q", string(data))
+	}
+
+	// custom providers are decompressed too
+	conn, _ = Open(dir)
+	conn.SetFileProvider(func(f string) (io.Reader, error) {
+		return bytes.NewReader(data), nil
+	})
+	table := new(recordTable)
+	if err = conn.RegisterTable("test", table); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.records) != 2 || string(table.records[1]) != "\"two\"" {
+		t.Fatalf("Expected: [one two], Got: %q", table.records)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	writer.SetAppendOnly(true)
+
+	if err = writer.RegisterTable("test", new(recordTable)); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Put("test", "one"); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := new(recordTable)
+	if err = reader.RegisterTable("test", table); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = reader.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.records) != 1 {
+		t.Fatalf("Expected: 1 record(s), Got: %d record(s)", len(table.records))
+	}
+
+	if err = writer.Put("test", "two", "three"); err != nil {
+		t.Fatal(err)
+	}
+	if err = reader.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.records) != 3 || string(table.records[2]) != "\"three\"" {
+		t.Fatalf("Expected: [one two three], Got: %q", table.records)
+	}
+
+	if err = writer.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err = reader.Reload("test"); err != ErrTableRewritten {
+		t.Fatalf("Expected: %v, Got: %v", ErrTableRewritten, err)
+	}
+}
+
+func TestReloadLeavesPartialRecords(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "jsondb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		first, second := []byte("\"one\"\n"), []byte("\"two\"\n")
+		if gzipped {
+			first, _ = compress(first)
+			second, _ = compress(second)
+		}
+
+		f := path.Join(dir, "test.json")
+		half := len(second) / 2
+		if err = writeFile(f, append(first, second[:half]...)); err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := OpenReadOnly(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table := new(recordTable)
+		if err = conn.RegisterTable("test", table); err != nil {
+			t.Fatal(err)
+		}
+		if err = conn.Reload("test"); err != nil {
+			t.Fatal(err)
+		}
+		if len(table.records) != 1 {
+			t.Fatalf("Expected: [one], Got: %q", table.records)
+		}
+
+		if err = appendData(f, second[half:]); err != nil {
+			t.Fatal(err)
+		}
+		if err = conn.Reload("test"); err != nil {
+			t.Fatal(err)
+		}
+		if len(table.records) != 2 || string(table.records[1]) != "\"two\"" {
+			t.Fatalf("Expected: [one two], Got: %q", table.records)
+		}
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"events", "views"} {
+		if err = conn.RegisterTable(name, new(recordTable)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Put("events", "one", "two")
+	conn.Put("views", "view")
+
+	archive := new(bytes.Buffer)
+	manifest, err := conn.Backup(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Tables) != 2 || manifest.Tables[0].Records != 2 {
+		t.Fatalf("Expected: 2 tables starting with 2 events, Got: %v", manifest)
+	}
+
+	if _, err = Restore(bytes.NewReader(archive.Bytes()), dir); err != ErrDirectoryNotEmpty {
+		t.Fatalf("Expected: %v, Got: %v", ErrDirectoryNotEmpty, err)
+	}
+
+	restored := path.Join(dir, "restored")
+	os.Mkdir(restored, 0755)
+	if _, err = Restore(bytes.NewReader(archive.Bytes()), restored); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		"events.json": "\"one\"\n\"two\"\n",
+		"views.json":  "\"view\"\n",
+	} {
+		data, err := ioutil.ReadFile(path.Join(restored, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Expected: %q, Got: %q", expected, string(data))
+		}
+	}
+
+	// a manifest that doesn't match leaves nothing behind
+	damaged := new(bytes.Buffer)
+	compressed := gzip.NewWriter(damaged)
+	writer := tar.NewWriter(compressed)
+	writeEntry(writer, "events.json", []byte("\"three\"\n"))
+	writeEntry(writer, manifestName, []byte(`{"tables": [{"name": "events", "sha256": "00"}]}`))
+	writer.Close()
+	compressed.Close()
+
+	// as does one whose record counts don't match its tables
+	data := []byte("\"three\"\n")
+	sum := sha256.Sum256(data)
+	miscounted := new(bytes.Buffer)
+	compressed = gzip.NewWriter(miscounted)
+	writer = tar.NewWriter(compressed)
+	writeEntry(writer, "events.json", data)
+	writeEntry(writer, manifestName, []byte(fmt.Sprintf(
+		`{"tables": [{"name": "events", "records": 2, "size": %d, "sha256": "%x"}]}`,
+		len(data),
+		sum,
+	)))
+	writer.Close()
+	compressed.Close()
+
+	// or one that was cut short
+	truncated := bytes.NewReader(archive.Bytes()[:archive.Len()/2])
+
+	for name, backup := range map[string]io.Reader{
+		"damaged":    damaged,
+		"miscounted": miscounted,
+		"truncated":  truncated,
+	} {
+		empty := path.Join(dir, name)
+		os.Mkdir(empty, 0755)
+		if _, err = Restore(backup, empty); err == nil {
+			t.Fatalf("%s: Expected an Error", name)
+		}
+
+		infos, err := ioutil.ReadDir(empty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) != 0 {
+			t.Fatalf("%s: Expected an empty directory, Got: %d file(s)", name, len(infos))
+		}
+	}
+}
+
+func TestReadOnlyBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	writer.SetAppendOnly(true)
+	if err = writer.RegisterTable("events", new(recordTable)); err != nil {
+		t.Fatal(err)
+	}
+	writer.Put("events", "one")
+
+	reader, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = reader.RegisterTable("events", new(recordTable)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the files are copied, not what the reader last loaded
+	writer.Put("events", "two")
+
+	manifest, err := reader.Backup(ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Tables) != 1 || manifest.Tables[0].Records != 2 {
+		t.Fatalf("Expected: 1 table with 2 events, Got: %v", manifest)
+	}
+
+	if err = writeFile(path.Join(dir, transactionFileName), []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reader.Backup(ioutil.Discard); err != ErrUnfinishedTransaction {
+		t.Fatalf("Expected: %v, Got: %v", ErrUnfinishedTransaction, err)
+	}
+}
+
+func TestDeleteIsWrittenByFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetAppendOnly(true)
+
+	table := new(recordTable)
+	if err = conn.RegisterTable("test", table); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Put("test", "one", "two"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = conn.Delete("test", `"one"`); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Delete("missing", `"one"`); err != ErrTableNotFound {
+		t.Fatalf("Expected: %v, Got: %v", ErrTableNotFound, err)
+	}
+	if _, err = table.Get(`"one"`); err != ErrRecordNotFound {
+		t.Fatalf("Expected: %v, Got: %v", ErrRecordNotFound, err)
+	}
+
+	if err = conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, "test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "\"two\"\n" {
+		t.Fatalf("Expected: %q, Got: %q", "\"two\"\n", string(data))
+	}
+}
+
+type recordTable struct {
+	records []json.RawMessage
+}
+
+func (r *recordTable) Scan() chan json.RawMessage {
+	records := make(chan json.RawMessage)
+	go func() {
+		defer close(records)
+		for _, record := range r.records {
+			records <- record
+		}
+	}()
+
+	return records
+}
+
+// ScanPrefix treats each record's json as its key.
+func (r *recordTable) ScanPrefix(prefix string) chan json.RawMessage {
+	records := make(chan json.RawMessage)
+	go func() {
+		defer close(records)
+		for _, record := range r.records {
+			if strings.HasPrefix(string(record), prefix) {
+				records <- record
+			}
+		}
+	}()
+
+	return records
+}
+
+func (r *recordTable) Get(key string) (json.RawMessage, error) {
+	for _, record := range r.records {
+		if string(record) == key {
+			return record, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (r *recordTable) Delete(key string) error {
+	for i, record := range r.records {
+		if string(record) == key {
+			r.records = append(r.records[:i], r.records[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (r *recordTable) Put(v interface{}) error {
+	record, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.records = append(r.records, record)
+	return nil
+}
+
+func (r *recordTable) Load(records chan json.RawMessage) error {
+	for record := range records {
+		r.records = append(r.records, record)
+	}
+
+	return nil
+}
+
 type mockTable struct {
 	t *testing.T
 }
@@ -121,10 +917,22 @@ func (m mockTable) Scan() chan json.RawMessage {
 	return nil
 }
 
+func (m mockTable) ScanPrefix(string) chan json.RawMessage {
+	return nil
+}
+
+func (m mockTable) Get(string) (json.RawMessage, error) {
+	return nil, ErrRecordNotFound
+}
+
 func (m mockTable) Put(interface{}) error {
 	return nil
 }
 
+func (m mockTable) Delete(string) error {
+	return nil
+}
+
 func (m mockTable) Load(records chan json.RawMessage) error {
 	for record := range records {
 		m.t.Log(string(record))
@@ -141,3 +949,14 @@ type mockFile struct {
 func (m mockFile) Close() error {
 	return nil
 }
+
+func appendData(f string, data []byte) error {
+	file, err := os.OpenFile(f, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	return err
+}
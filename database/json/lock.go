@@ -0,0 +1,166 @@
+package json
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	// ErrLocked returned by Open when another process has the directory open
+	// for writing.
+	ErrLocked = errors.New("Database is locked by another process")
+	// ErrReadOnly returned when writing through a connection opened with
+	// OpenReadOnly.
+	ErrReadOnly = errors.New("Database is read only")
+)
+
+const (
+	// lockFileName is the file in a database directory that writers lock.
+	lockFileName = ".lock"
+	// writeLockFileName is the file in a database directory that the writer
+	// holds a shared lock on while it writes table files, and a backup holds
+	// an exclusive lock on while it copies them.
+	writeLockFileName = ".write"
+)
+
+// dirLock is a lock on a database directory, shared by every connection to it
+// in this process.
+type dirLock struct {
+	file  *os.File
+	count int
+
+	writes  *os.File
+	writing int
+	sync.Mutex
+}
+
+var (
+	locks      = make(map[string]*dirLock)
+	locksMutex sync.Mutex
+)
+
+// lockDir takes an exclusive advisory lock on dir, or another reference to it
+// when this process already holds it. The returned function releases it.
+func lockDir(dir string) (*dirLock, func() error, error) {
+	key, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	locksMutex.Lock()
+	defer locksMutex.Unlock()
+
+	lock, ok := locks[key]
+	if !ok {
+		file, err := os.OpenFile(
+			filepath.Join(key, lockFileName),
+			os.O_RDWR|os.O_CREATE,
+			0644,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err = flock(file); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		writes, err := openWriteLock(key)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		lock = &dirLock{file: file, writes: writes}
+		locks[key] = lock
+	}
+	lock.count++
+
+	var once sync.Once
+	return lock, func() (err error) {
+		once.Do(func() {
+			locksMutex.Lock()
+			defer locksMutex.Unlock()
+
+			if lock.count--; lock.count > 0 {
+				return
+			}
+
+			delete(locks, key)
+			lock.writes.Close()
+			err = lock.file.Close()
+		})
+		return
+	}, nil
+}
+
+// beginWrite takes a shared lock on the directory's write lock, waiting for
+// any backup being taken to finish, unless a write in this process already
+// holds it. Every beginWrite must be followed by an endWrite.
+func (l *dirLock) beginWrite() error {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.writing == 0 {
+		if err := flockShared(l.writes); err != nil {
+			return err
+		}
+	}
+	l.writing++
+
+	return nil
+}
+
+// endWrite releases the write lock once the last write in this process ends.
+func (l *dirLock) endWrite() {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.writing--; l.writing == 0 {
+		funlock(l.writes)
+	}
+}
+
+// beginWrite keeps a backup from copying the connection's files until
+// endWrite is called. Read only connections never write, so they don't lock.
+func (c *Connection) beginWrite() error {
+	if c.lock == nil {
+		return nil
+	}
+
+	return c.lock.beginWrite()
+}
+
+func (c *Connection) endWrite() {
+	if c.lock != nil {
+		c.lock.endWrite()
+	}
+}
+
+// lockWrites takes an exclusive lock on dir's write lock, waiting for the
+// writer to finish what it is writing and keeping it from writing anything
+// else until the returned function is called.
+func lockWrites(dir string) (func() error, error) {
+	file, err := openWriteLock(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = flockExclusive(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file.Close, nil
+}
+
+func openWriteLock(dir string) (*os.File, error) {
+	return os.OpenFile(
+		filepath.Join(dir, writeLockFileName),
+		os.O_RDWR|os.O_CREATE,
+		0644,
+	)
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package json
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock locks file without waiting, returning ErrLocked when another process
+// holds it. The lock is released when the file is closed.
+func flock(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrLocked
+	}
+
+	return err
+}
+
+// flockShared waits for a shared lock on file.
+func flockShared(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_SH)
+}
+
+// flockExclusive waits for an exclusive lock on file.
+func flockExclusive(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+// funlock releases a lock on file without closing it.
+func funlock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
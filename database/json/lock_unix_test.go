@@ -0,0 +1,123 @@
+//go:build !windows
+// +build !windows
+
+package json
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpenLocksDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a second connection in the same process shares the lock
+	other, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	conn.Close()
+
+	// stands in for another process holding the lock
+	file, err := os.OpenFile(path.Join(dir, lockFileName), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Open(dir); err != ErrLocked {
+		t.Fatalf("Expected: %v, Got: %v", ErrLocked, err)
+	}
+
+	if conn, err = OpenReadOnly(dir); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestBackupWaitsForWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsondb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	writer.SetAppendOnly(true)
+	if err = writer.RegisterTable("test", new(recordTable)); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = reader.RegisterTable("test", new(recordTable)); err != nil {
+		t.Fatal(err)
+	}
+
+	// stands in for a commit part way through
+	if err = writer.beginWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	backedUp := make(chan error)
+	go func() {
+		_, err := reader.Backup(ioutil.Discard)
+		backedUp <- err
+	}()
+
+	select {
+	case <-backedUp:
+		t.Fatal("Expected the backup to wait for the write")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	writer.endWrite()
+	if err = <-backedUp; err != nil {
+		t.Fatal(err)
+	}
+
+	// and the writer waits for a backup
+	unlock, err := lockWrites(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := make(chan error)
+	go func() {
+		put <- writer.Put("test", "one")
+	}()
+
+	select {
+	case <-put:
+		t.Fatal("Expected the put to wait for the backup")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	if err = <-put; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,79 @@
+//go:build windows
+// +build windows
+
+package json
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// flock locks file without waiting, returning ErrLocked when another process
+// holds it. The lock is released when the file is closed.
+func flock(file *os.File) error {
+	err := lockFileEx(file, lockfileExclusiveLock|lockfileFailImmediately)
+	if err == errorLockViolation {
+		return ErrLocked
+	}
+
+	return err
+}
+
+// flockShared waits for a shared lock on file.
+func flockShared(file *os.File) error {
+	return lockFileEx(file, 0)
+}
+
+// flockExclusive waits for an exclusive lock on file.
+func flockExclusive(file *os.File) error {
+	return lockFileEx(file, lockfileExclusiveLock)
+}
+
+// funlock releases a lock on file without closing it.
+func funlock(file *os.File) error {
+	overlapped := new(syscall.Overlapped)
+	r, _, err := procUnlockFileEx.Call(
+		file.Fd(),
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(overlapped)),
+	)
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// lockFileEx locks the first byte of file, which stands for the whole of it.
+func lockFileEx(file *os.File, flags uint32) error {
+	overlapped := new(syscall.Overlapped)
+	r, _, err := procLockFileEx.Call(
+		file.Fd(),
+		uintptr(flags),
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(overlapped)),
+	)
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package json
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+var (
+	// ErrTableRewritten returned by Reload when a table's file was replaced
+	// rather than appended to, so the table has to be emptied and registered
+	// again to pick up its records.
+	ErrTableRewritten = errors.New("Table File Rewritten")
+)
+
+// Reload passes the records appended to a table's file since it was last read
+// to the table's Load, which costs no more than a stat when the file has not
+// changed.
+func (c *Connection) Reload(name string) error {
+	c.Lock()
+	table, ok := c.tables[name]
+	j := c.journals[name]
+	c.Unlock()
+
+	if !ok {
+		return ErrTableNotFound
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	info, err := os.Stat(path.Join(c.path, name+".json"))
+	switch {
+	case os.IsNotExist(err):
+		if j.info == nil {
+			return nil
+		}
+		return ErrTableRewritten
+	case err != nil:
+		return err
+	case j.info != nil && !os.SameFile(j.info, info):
+		return ErrTableRewritten
+	case info.Size() < j.offset:
+		return ErrTableRewritten
+	case info.Size() == j.offset:
+		return nil
+	}
+
+	return c.load(name, table, j)
+}
+
+// wrote moves the journal's offset past data written to the end of the
+// table's file. Must be called with the journal locked.
+func (c *Connection) wrote(name string, j *journal, data []byte) {
+	j.offset += int64(len(data))
+	if j.info == nil {
+		j.info, _ = os.Stat(path.Join(c.path, name+".json"))
+	}
+}
+
+// rewrote moves the journal's offset to the end of a table's new file. Must be
+// called with the journal locked.
+func (c *Connection) rewrote(name string, j *journal) {
+	j.offset, j.info = 0, nil
+	if info, err := os.Stat(path.Join(c.path, name+".json")); err == nil {
+		j.offset, j.info = info.Size(), info
+	}
+}
+
+// skip reads past the first offset bytes of file.
+func skip(file io.Reader, offset int64) error {
+	if offset < 1 {
+		return nil
+	}
+
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+
+	_, err := io.CopyN(ioutil.Discard, file, offset)
+	return err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
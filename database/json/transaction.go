@@ -0,0 +1,315 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+)
+
+var (
+	// ErrTransactionDone returned when using a transaction that has already
+	// been committed or rolled back.
+	ErrTransactionDone = errors.New("Transaction Already Committed or Rolled Back")
+)
+
+// transactionFileName is the log a commit writes before touching any table
+// file, so an interrupted commit can be finished when the directory is next
+// opened.
+const transactionFileName = ".transaction"
+
+// Transaction stages puts to any of a connection's tables until Commit writes
+// them all to disk, or Rollback drops them.
+type Transaction struct {
+	conn *Connection
+	puts []stagedPut
+	done bool
+}
+
+type stagedPut struct {
+	name   string
+	values []interface{}
+}
+
+// transactionLog records where each table file ended before a commit and the
+// records appended to it.
+type transactionLog struct {
+	Tables []transactionTable `json:"tables"`
+}
+
+type transactionTable struct {
+	Name    string            `json:"name"`
+	Offset  int64             `json:"offset"`
+	Records []json.RawMessage `json:"records"`
+}
+
+// Begin starts a transaction on the connection.
+func (c *Connection) Begin() (*Transaction, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	tx := new(Transaction)
+	tx.conn = c
+	return tx, nil
+}
+
+// Put stages values to be passed to the named table's Put on Commit.
+func (t *Transaction) Put(name string, values ...interface{}) error {
+	if t.done {
+		return ErrTransactionDone
+	}
+
+	t.puts = append(t.puts, stagedPut{name: name, values: values})
+	return nil
+}
+
+// Commit appends every staged record to its table's file, on append only
+// connections and otherwise alike, and then hands them to the tables. Either
+// all of the records are written or, after a crash, none of them are until the
+// directory is opened again and the commit is finished.
+func (t *Transaction) Commit() error {
+	if t.done {
+		return ErrTransactionDone
+	}
+	t.done = true
+
+	c := t.conn
+	c.Lock()
+	defer c.Unlock()
+
+	var (
+		names   []string
+		records = make(map[string][]json.RawMessage)
+		txLog   transactionLog
+	)
+
+	for _, put := range t.puts {
+		if _, ok := c.tables[put.name]; !ok {
+			return ErrTableNotFound
+		}
+
+		if _, ok := records[put.name]; !ok {
+			names = append(names, put.name)
+		}
+
+		for _, v := range put.values {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			records[put.name] = append(records[put.name], data)
+		}
+	}
+
+	if len(names) < 1 {
+		return nil
+	}
+
+	// a backup must see all of the commit or none of it
+	if err := c.beginWrite(); err != nil {
+		return err
+	}
+	defer c.endWrite()
+
+	// journals are always locked in the same order so commits can't deadlock
+	sort.Strings(names)
+	for _, name := range names {
+		j := c.journals[name]
+		j.Lock()
+		defer j.Unlock()
+
+		offset, err := fileSize(path.Join(c.path, name+".json"))
+		if err != nil {
+			return err
+		}
+
+		txLog.Tables = append(txLog.Tables, transactionTable{
+			Name:    name,
+			Offset:  offset,
+			Records: records[name],
+		})
+	}
+
+	if err := c.writeTransaction(txLog); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		data := joinRecords(records[name])
+		if err := c.append(name, c.journals[name], data); err != nil {
+			if undoErr := c.undoTransaction(txLog); undoErr != nil {
+				log.Println("Error: Undoing transaction: ", undoErr)
+			}
+			return err
+		}
+	}
+
+	if err := removeFile(path.Join(c.path, transactionFileName)); err != nil {
+		return err
+	}
+
+	for _, put := range t.puts {
+		table := c.tables[put.name]
+		for _, v := range put.values {
+			if err := table.Put(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Rollback drops every staged put.
+func (t *Transaction) Rollback() error {
+	if t.done {
+		return ErrTransactionDone
+	}
+	t.done = true
+	t.puts = nil
+
+	return nil
+}
+
+// writeTransaction writes the log that makes a commit durable. Must be called
+// with the connection locked.
+func (c *Connection) writeTransaction(txLog transactionLog) error {
+	data, err := json.Marshal(txLog)
+	if err != nil {
+		return err
+	}
+
+	file, err := c.fileCreator(path.Join(c.path, transactionFileName))
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		abort(file)
+		return err
+	}
+
+	if closer, ok := file.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// undoTransaction cuts the table files back to where they ended before the
+// commit and removes its log. Must be called with the connection and the
+// journals of the commit's tables locked.
+func (c *Connection) undoTransaction(txLog transactionLog) error {
+	for _, table := range txLog.Tables {
+		j := c.journals[table.Name]
+		if err := j.close(); err != nil {
+			return err
+		}
+		j.offset = table.Offset
+
+		err := os.Truncate(path.Join(c.path, table.Name+".json"), table.Offset)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return removeFile(path.Join(c.path, transactionFileName))
+}
+
+// recoverTransaction finishes a commit that was interrupted after its log was
+// written, replacing anything it had already appended to the table files.
+func recoverTransaction(dir string) error {
+	if err := removeFile(path.Join(dir, transactionFileName+tempSuffix)); err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, transactionFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	log.Println("Warn: Finishing interrupted transaction in: ", dir)
+
+	var txLog transactionLog
+	if err = json.Unmarshal(data, &txLog); err != nil {
+		return err
+	}
+
+	for _, table := range txLog.Tables {
+		f := path.Join(dir, table.Name+".json")
+		if err = os.Truncate(f, table.Offset); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err = appendRecords(f, table.Records); err != nil {
+			return err
+		}
+	}
+
+	return removeFile(path.Join(dir, transactionFileName))
+}
+
+// appendRecords writes records to the end of f, one per line, and syncs it.
+// They are compressed when the file is.
+func appendRecords(f string, records []json.RawMessage) error {
+	data := joinRecords(records)
+	if gzipped, err := isGzipFile(f); err != nil {
+		return err
+	} else if gzipped {
+		if data, err = compress(data); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(f, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// joinRecords puts records one per line, the way table files are written.
+func joinRecords(records []json.RawMessage) []byte {
+	buffer := new(bytes.Buffer)
+	for _, record := range records {
+		buffer.Write(record)
+		buffer.WriteByte('\n')
+	}
+
+	return buffer.Bytes()
+}
+
+func fileSize(f string) (int64, error) {
+	stat, err := os.Stat(f)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return stat.Size(), nil
+}
+
+func removeFile(f string) error {
+	if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
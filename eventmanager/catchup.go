@@ -0,0 +1,208 @@
+package eventmanager
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ebittleman/voting/eventstore"
+)
+
+// Bounds of the delay between replays while a live event waits for the store
+// to fill the gap before it.
+var (
+	minReplayDelay = 10 * time.Millisecond
+	maxReplayDelay = time.Second
+)
+
+// CatchUpSubscription replays events from an event store and then switches to
+// live events published to an event manager.
+type CatchUpSubscription interface {
+	// Position of the last event passed to the handler.
+	Position() int64
+	// CaughtUp is closed once every stored event has been replayed.
+	CaughtUp() <-chan struct{}
+	io.Closer
+}
+
+type catchUpSubscription struct {
+	eventManager EventManager
+	eventStore   eventstore.EventStore
+	handler      EventHandler
+	eventTypes   map[string]bool
+	subs         []Subscription
+
+	position int64
+	live     chan eventstore.Event
+
+	caughtUp chan struct{}
+	done     chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+
+	sync.RWMutex
+}
+
+// SubscribeFrom passes every event of the given types appended to the store
+// after position to handler, and then every one published to the event manager
+// after that. Live events are subscribed to before replaying starts and are
+// held until it finishes, so nothing appended in between is missed, and any
+// live event at or before the last handled position is dropped as a
+// duplicate. The event manager can deliver live events out of order, so one
+// that skips ahead of the last handled position is only handled after the
+// events before it are read from the store, waiting for the store to commit
+// them if it hasn't yet. Events are handled one at a time, in order.
+func SubscribeFrom(
+	eventManager EventManager,
+	eventStore eventstore.EventStore,
+	position int64,
+	eventTypes []string,
+	handler EventHandler,
+) CatchUpSubscription {
+	c := new(catchUpSubscription)
+	c.eventManager = eventManager
+	c.eventStore = eventStore
+	c.handler = handler
+	c.position = position
+	c.eventTypes = make(map[string]bool, len(eventTypes))
+	c.live = make(chan eventstore.Event)
+	c.caughtUp = make(chan struct{})
+	c.done = make(chan struct{})
+	c.closed = make(chan struct{})
+
+	for _, eventType := range eventTypes {
+		c.eventTypes[eventType] = true
+		c.subs = append(c.subs, eventManager.Subscribe(eventType, c.receive))
+	}
+
+	go c.loop()
+
+	return c
+}
+
+func (c *catchUpSubscription) Position() int64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.position
+}
+
+func (c *catchUpSubscription) CaughtUp() <-chan struct{} {
+	return c.caughtUp
+}
+
+// receive is subscribed to the event manager and hands live events to loop.
+func (c *catchUpSubscription) receive(event eventstore.Event) error {
+	select {
+	case c.live <- event:
+	case <-c.done:
+	}
+	return nil
+}
+
+func (c *catchUpSubscription) loop() {
+	defer close(c.closed)
+
+	if err := c.replay(); err != nil {
+		log.Println("Error: Replaying events: ", err)
+	}
+	close(c.caughtUp)
+
+	for {
+		select {
+		case event := <-c.live:
+			c.receiveLive(event)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// receiveLive handles a live event in position order. Events the store has
+// that the event manager hasn't delivered yet are replayed first, and once they
+// are handled their own live copies are dropped as duplicates. A live event
+// past a gap is held until the store has the events before it, replaying again
+// with a growing delay, so nothing is skipped while a write is still pending.
+func (c *catchUpSubscription) receiveLive(event eventstore.Event) {
+	if event.Position == 0 {
+		c.handle(event)
+		return
+	}
+
+	for delay := minReplayDelay; event.Position > c.Position()+1; {
+		if err := c.replay(); err != nil {
+			log.Println("Error: Replaying events: ", err)
+		}
+
+		if event.Position <= c.Position()+1 {
+			break
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return
+		}
+
+		if delay *= 2; delay > maxReplayDelay {
+			delay = maxReplayDelay
+		}
+	}
+
+	if event.Position <= c.Position() {
+		return
+	}
+
+	c.handle(event)
+}
+
+func (c *catchUpSubscription) replay() error {
+	it := c.eventStore.IterateAll(c.Position(), 0)
+	defer it.Close()
+
+	for it.Next() {
+		select {
+		case <-c.done:
+			return nil
+		default:
+		}
+
+		event := it.Event()
+		if !c.eventTypes[event.Type] {
+			c.setPosition(event.Position)
+			continue
+		}
+		c.handle(event)
+	}
+
+	return it.Err()
+}
+
+func (c *catchUpSubscription) handle(event eventstore.Event) {
+	if err := c.handler(event); err != nil {
+		log.Println("Error: ", err)
+	}
+	c.setPosition(event.Position)
+}
+
+func (c *catchUpSubscription) setPosition(position int64) {
+	c.Lock()
+	defer c.Unlock()
+	if position > c.position {
+		c.position = position
+	}
+}
+
+func (c *catchUpSubscription) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		for _, sub := range c.subs {
+			if err := c.eventManager.Unsubscribe(sub); err != nil {
+				log.Println("Warn: Unsubscribing Handler: ", err)
+			}
+		}
+	})
+
+	<-c.closed
+	return nil
+}
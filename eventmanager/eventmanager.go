@@ -1,10 +1,12 @@
 package eventmanager
 
 import (
+	"context"
 	"errors"
 	"io"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/ebittleman/voting/eventstore"
 )
@@ -14,10 +16,23 @@ var (
 )
 
 type EventHandler func(eventstore.Event) error
+
+// ContextHandler is an EventHandler that is told when to give up. Its context
+// is done once the subscription's timeout passes or the event manager is
+// closed.
+type ContextHandler func(context.Context, eventstore.Event) error
+
 type Subscription interface{}
 type EventManager interface {
 	Publish(event eventstore.Event)
 	Subscribe(eventType string, handler EventHandler) Subscription
+	// SubscribeContext subscribes a ContextHandler, giving it timeout to
+	// handle each event. A timeout of zero never times out.
+	SubscribeContext(
+		eventType string,
+		handler ContextHandler,
+		timeout time.Duration,
+	) Subscription
 	Unsubscribe(v Subscription) error
 	io.Closer
 }
@@ -26,14 +41,32 @@ type Subscriber interface {
 	io.Closer
 }
 
+// WithContext adapts an EventHandler so it can be subscribed with a timeout.
+// The handler can't be interrupted, so once the context is done the event is
+// reported as failed with the context's error and the handler is left to
+// finish in the background.
+func WithContext(handler EventHandler) ContextHandler {
+	return func(ctx context.Context, event eventstore.Event) error {
+		return wait(
+			ctx,
+			func(_ context.Context, event eventstore.Event) error {
+				return handler(event)
+			},
+			event,
+		)
+	}
+}
+
 type subscription struct {
 	eventType string
-	handler   EventHandler
+	handler   ContextHandler
+	timeout   time.Duration
 }
 
 type subscribeReq struct {
 	eventType string
-	handler   EventHandler
+	handler   ContextHandler
+	timeout   time.Duration
 	resp      chan Subscription
 }
 
@@ -52,6 +85,9 @@ type eventManager struct {
 	done   chan chan error
 	closed chan struct{}
 
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	sync.WaitGroup
 }
 
@@ -63,7 +99,10 @@ func New() EventManager {
 }
 
 func (e *eventManager) init() {
+	ctx, cancel := context.WithCancel(context.Background())
 	*e = eventManager{
+		ctx:           ctx,
+		cancel:        cancel,
 		subscriptions: make(map[string][]Subscription),
 		publishCh:     make(chan eventstore.Event),
 		subscribeCh:   make(chan subscribeReq),
@@ -81,11 +120,12 @@ func (e *eventManager) loop() {
 		case event := <-e.publishCh:
 			e.publish(event)
 		case req := <-e.subscribeCh:
-			req.resp <- e.subscribe(req.eventType, req.handler)
+			req.resp <- e.subscribe(req.eventType, req.handler, req.timeout)
 		case req := <-e.unsubscribeCh:
 			e.unsubscribe(req.sub)
 			req.resp <- nil
 		case errCh := <-e.done:
+			e.cancel()
 			e.Wait()
 			log.Println("Debug: All Events Processed")
 			errCh <- nil
@@ -105,17 +145,46 @@ func (e *eventManager) publish(event eventstore.Event) {
 		e.Add(1)
 		go func(sub *subscription) {
 			defer e.Done()
-			if err := sub.handler(event); err != nil {
+
+			ctx, cancel := e.ctx, context.CancelFunc(func() {})
+			if sub.timeout > 0 {
+				ctx, cancel = context.WithTimeout(e.ctx, sub.timeout)
+			}
+			defer cancel()
+
+			if err := wait(ctx, sub.handler, event); err != nil {
 				log.Println("Error: ", err)
 			}
 		}(sub.(*subscription))
 	}
 }
 
-func (e *eventManager) subscribe(eventType string, handler EventHandler) Subscription {
+// wait runs handler until it returns or ctx is done, whichever comes first. A
+// handler still running once ctx is done is left to finish in the background,
+// so it can't hold up closing the event manager.
+func wait(ctx context.Context, handler ContextHandler, event eventstore.Event) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- handler(ctx, event)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *eventManager) subscribe(
+	eventType string,
+	handler ContextHandler,
+	timeout time.Duration,
+) Subscription {
 	sub := new(subscription)
 	sub.eventType = eventType
 	sub.handler = handler
+	sub.timeout = timeout
 
 	subscriptions, _ := e.subscriptions[eventType]
 	e.subscriptions[eventType] = append(subscriptions, sub)
@@ -155,13 +224,31 @@ func (e *eventManager) Publish(event eventstore.Event) {
 	}
 }
 
+// Subscribe passes every published event of eventType to handler. Closing the
+// event manager stops waiting for the handler and leaves it to finish in the
+// background.
 func (e *eventManager) Subscribe(
 	eventType string,
 	handler EventHandler,
+) Subscription {
+	return e.SubscribeContext(
+		eventType,
+		func(_ context.Context, event eventstore.Event) error {
+			return handler(event)
+		},
+		0,
+	)
+}
+
+func (e *eventManager) SubscribeContext(
+	eventType string,
+	handler ContextHandler,
+	timeout time.Duration,
 ) Subscription {
 	req := subscribeReq{
 		eventType: eventType,
 		handler:   handler,
+		timeout:   timeout,
 		resp:      make(chan Subscription),
 	}
 
@@ -193,6 +280,8 @@ func (e *eventManager) Unsubscribe(v Subscription) error {
 	return <-req.resp
 }
 
+// Close cancels the context of every handler still running and returns once
+// they have all returned or been left to finish in the background.
 func (e *eventManager) Close() error {
 	errCh := make(chan error)
 
@@ -1,10 +1,14 @@
 package eventmanager
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ebittleman/voting/eventstore"
+	"github.com/ebittleman/voting/eventstore/json/jsontest"
 )
 
 func TestPublish(t *testing.T) {
@@ -51,6 +55,285 @@ func TestUnsubscribe(t *testing.T) {
 	}
 }
 
+func TestSubscribeContextTimesOut(t *testing.T) {
+	var events eventManager
+	events.init()
+	defer events.Close()
+
+	errCh := make(chan error, 1)
+	events.SubscribeContext(
+		"testEvent",
+		func(ctx context.Context, event eventstore.Event) error {
+			<-ctx.Done()
+			errCh <- ctx.Err()
+			return ctx.Err()
+		},
+		time.Millisecond,
+	)
+
+	events.Publish(eventstore.Event{Type: "testEvent"})
+
+	select {
+	case err := <-errCh:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Expected: %v, Got: %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the handler to time out")
+	}
+}
+
+func TestCloseCancelsHandlers(t *testing.T) {
+	var events eventManager
+	events.init()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+
+	events.SubscribeContext(
+		"testEvent",
+		WithContext(func(event eventstore.Event) error {
+			close(started)
+			<-release
+			return nil
+		}),
+		0,
+	)
+
+	events.Publish(eventstore.Event{Type: "testEvent"})
+	<-started
+
+	closed := make(chan error)
+	go func() {
+		closed <- events.Close()
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close not to wait for the handler")
+	}
+}
+
+func TestCloseDoesNotWaitForHandlers(t *testing.T) {
+	var events eventManager
+	events.init()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+
+	events.Subscribe("testEvent", func(event eventstore.Event) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	events.Publish(eventstore.Event{Type: "testEvent"})
+	<-started
+
+	closed := make(chan error)
+	go func() {
+		closed <- events.Close()
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close not to wait for the handler")
+	}
+}
+
+func TestSubscribeFrom(t *testing.T) {
+	store := jsontest.NewStore(t, testData)
+
+	var events eventManager
+	events.init()
+	defer events.Close()
+
+	var (
+		handled []int64
+		mu      sync.Mutex
+	)
+	sub := SubscribeFrom(&events, store, 1, []string{"testEvent"},
+		func(event eventstore.Event) error {
+			mu.Lock()
+			handled = append(handled, event.Position)
+			mu.Unlock()
+			return nil
+		},
+	)
+	defer sub.Close()
+	<-sub.CaughtUp()
+
+	// already replayed from the store, so it must not be handled twice.
+	events.Publish(eventstore.Event{Type: "testEvent", Position: 3})
+
+	live := eventstore.Events{
+		eventstore.Event{ID: "id", Version: 4, Type: "testEvent"},
+	}
+	if err := store.Append("id", 3, live); err != nil {
+		t.Fatal(err)
+	}
+	events.Publish(live[0])
+
+	for timeout := time.After(time.Second); sub.Position() < 4; {
+		select {
+		case <-timeout:
+			t.Fatal("Timed out waiting for the live event")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	sub.Close()
+
+	expected := []int64{3, 4}
+	if len(handled) != len(expected) {
+		t.Fatalf("Expected: %v, Got: %v", expected, handled)
+	}
+	for i, position := range expected {
+		if handled[i] != position {
+			t.Fatalf("Expected: %v, Got: %v", expected, handled)
+		}
+	}
+}
+
+func TestSubscribeFromHandlesLiveEventsInOrder(t *testing.T) {
+	store := jsontest.NewStore(t, "")
+
+	var events eventManager
+	events.init()
+	defer events.Close()
+
+	var handled []int64
+	sub := SubscribeFrom(&events, store, 0, []string{"testEvent"},
+		func(event eventstore.Event) error {
+			handled = append(handled, event.Position)
+			return nil
+		},
+	)
+	defer sub.Close()
+	<-sub.CaughtUp()
+
+	const total = 2000
+	for i := int64(0); i < total; i++ {
+		id := fmt.Sprintf("id%d", i)
+		live := eventstore.Events{
+			eventstore.Event{ID: id, Version: 1, Type: "testEvent"},
+		}
+		if err := store.Append(id, 0, live); err != nil {
+			t.Fatal(err)
+		}
+		events.Publish(live[0])
+	}
+
+	for timeout := time.After(5 * time.Second); sub.Position() < total; {
+		select {
+		case <-timeout:
+			t.Fatalf("Timed out at position %d", sub.Position())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	sub.Close()
+
+	if len(handled) != total {
+		t.Fatalf("Expected %d events, Got: %d", total, len(handled))
+	}
+	for i, position := range handled {
+		if position != int64(i+1) {
+			t.Fatalf("Expected position %d, Got: %d", i+1, position)
+		}
+	}
+}
+
+func TestSubscribeFromWaitsForHeldBackPosition(t *testing.T) {
+	jsonStore := jsontest.NewStore(t, "")
+	store := &holdBackStore{EventStore: jsonStore, hold: 1}
+
+	var events eventManager
+	events.init()
+	defer events.Close()
+
+	var handled []int64
+	sub := SubscribeFrom(&events, store, 0, []string{"testEvent"},
+		func(event eventstore.Event) error {
+			handled = append(handled, event.Position)
+			return nil
+		},
+	)
+	defer sub.Close()
+	<-sub.CaughtUp()
+
+	for _, id := range []string{"id1", "id2"} {
+		live := eventstore.Events{
+			eventstore.Event{ID: id, Version: 1, Type: "testEvent"},
+		}
+		if err := store.Append(id, 0, live); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// position 1 is still pending in the store when 2 is published.
+	second, _ := store.Query("id2")
+	events.Publish(second[0])
+
+	time.Sleep(50 * time.Millisecond)
+	if position := sub.Position(); position != 0 {
+		t.Fatalf("Expected to wait at position 0, Got: %d", position)
+	}
+
+	store.release()
+
+	for timeout := time.After(5 * time.Second); sub.Position() < 2; {
+		select {
+		case <-timeout:
+			t.Fatalf("Timed out at position %d", sub.Position())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	sub.Close()
+
+	expected := []int64{1, 2}
+	if len(handled) != len(expected) {
+		t.Fatalf("Expected: %v, Got: %v", expected, handled)
+	}
+	for i, position := range expected {
+		if handled[i] != position {
+			t.Fatalf("Expected: %v, Got: %v", expected, handled)
+		}
+	}
+}
+
+// holdBackStore stops IterateAll before the hold position, the way a store
+// with a write still pending at that position does.
+type holdBackStore struct {
+	eventstore.EventStore
+	hold int64
+	sync.Mutex
+}
+
+func (s *holdBackStore) IterateAll(position int64, limit int) eventstore.Iterator {
+	s.Lock()
+	defer s.Unlock()
+	return &holdBackIterator{s.EventStore.IterateAll(position, limit), s.hold}
+}
+
+func (s *holdBackStore) release() {
+	s.Lock()
+	defer s.Unlock()
+	s.hold = 0
+}
+
+type holdBackIterator struct {
+	eventstore.Iterator
+	hold int64
+}
+
+func (it *holdBackIterator) Next() bool {
+	if !it.Iterator.Next() {
+		return false
+	}
+	return it.hold == 0 || it.Event().Position < it.hold
+}
+
 type mockHandler struct {
 	called int
 	sync.WaitGroup
@@ -64,3 +347,8 @@ func (m *mockHandler) handle(_ eventstore.Event) error {
 	m.Done()
 	return nil
 }
+
+const testData = `{"id":"id","version":1,"type":"testEvent","timestamp":1486332029}
+{"id":"id","version":2,"type":"otherEvent","timestamp":1486332324}
+{"id":"id","version":3,"type":"testEvent","timestamp":1486332354}
+`
@@ -0,0 +1,199 @@
+package eventstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// chainLink the parts of an event covered by its hash. Position and Snapshot
+// are left out as they are filled in by the store rather than the writer.
+type chainLink struct {
+	Previous      string           `json:"previous"`
+	ID            string           `json:"id"`
+	EventID       string           `json:"event_id"`
+	Version       int64            `json:"version"`
+	Type          string           `json:"type"`
+	SchemaVersion int              `json:"schema_version"`
+	Timestamp     int64            `json:"timestamp"`
+	Data          *json.RawMessage `json:"data"`
+	Metadata      Metadata         `json:"metadata"`
+}
+
+// ChainHash hashes an event together with the hash of the event before it in
+// its stream, so changing or removing any event changes the hash of every
+// event after it.
+func ChainHash(previous string, event Event) (string, error) {
+	data, err := json.Marshal(chainLink{
+		Previous:      previous,
+		ID:            event.ID,
+		EventID:       event.EventID,
+		Version:       event.Version,
+		Type:          event.Type,
+		SchemaVersion: event.SchemaVersion,
+		Timestamp:     event.Timestamp,
+		Data:          event.Data,
+		Metadata:      event.Metadata,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Chain sets the Hash of each event, starting from the hash of the last event
+// already in the stream.
+func Chain(previous string, events Events) (err error) {
+	for i := range events {
+		if events[i].Hash, err = ChainHash(previous, events[i]); err != nil {
+			return err
+		}
+		previous = events[i].Hash
+	}
+
+	return nil
+}
+
+// ErrChainBroken returned by ChainVerifier when an event does not follow on
+// from the one before it in its stream.
+type ErrChainBroken struct {
+	StreamID string
+	Version  int64
+	Reason   string
+}
+
+func (e *ErrChainBroken) Error() string {
+	return fmt.Sprintf(
+		"Chain broken: stream %s version %d: %s",
+		e.StreamID,
+		e.Version,
+		e.Reason,
+	)
+}
+
+// ChainHead is the last event of a stream seen by a ChainVerifier. Version is
+// also the number of events in the stream.
+type ChainHead struct {
+	Version int64  `json:"version"`
+	Hash    string `json:"hash"`
+}
+
+// Anchor records the head of every stream at some point, so a later check can
+// tell when events have been removed from the end of a stream, or a whole
+// stream has been removed, which the links between events can't show.
+type Anchor map[string]ChainHead
+
+// ChainVerifier checks the hash chains of many streams at once, from events
+// passed to it in the order they were appended. Only the head of each stream
+// is kept in memory.
+type ChainVerifier struct {
+	heads  map[string]ChainHead
+	anchor Anchor
+	legacy int
+}
+
+// NewChainVerifier creates a verifier that has seen no events yet. Every
+// stream in anchor must reach its anchored head with the same hash, a nil
+// anchor checks only the links between events.
+func NewChainVerifier(anchor Anchor) *ChainVerifier {
+	v := new(ChainVerifier)
+	v.heads = make(map[string]ChainHead)
+	v.anchor = anchor
+	return v
+}
+
+// Check verifies the next event of a stream against the one before it, and
+// returns an *ErrChainBroken if it has been altered, is missing its hash or if
+// events before it are missing. The event becomes the head of its stream
+// either way, so one bad event is reported once rather than breaking the rest
+// of the stream. Events at the start of a stream stored before events were
+// hashed have no hash to check and are counted as legacy.
+func (v *ChainVerifier) Check(event Event) error {
+	head := v.heads[event.ID]
+	v.heads[event.ID] = ChainHead{Version: event.Version, Hash: event.Hash}
+
+	broken := &ErrChainBroken{StreamID: event.ID, Version: event.Version}
+	if event.Version != head.Version+1 {
+		broken.Reason = fmt.Sprintf("expected version %d", head.Version+1)
+		return broken
+	}
+
+	if anchored, ok := v.anchor[event.ID]; ok &&
+		anchored.Version == event.Version &&
+		anchored.Hash != event.Hash {
+		broken.Reason = "hash does not match anchor"
+		return broken
+	}
+
+	if event.Hash == "" {
+		if head.Hash != "" {
+			broken.Reason = "missing hash"
+			return broken
+		}
+
+		v.legacy++
+		return nil
+	}
+
+	expected, err := ChainHash(head.Hash, event)
+	if err != nil {
+		return err
+	}
+
+	if event.Hash != expected {
+		broken.Reason = "hash does not match"
+		return broken
+	}
+
+	return nil
+}
+
+// Finish returns an *ErrChainBroken for every anchored stream that no longer
+// reaches its anchored head, once every event has been checked.
+func (v *ChainVerifier) Finish() []error {
+	ids := make([]string, 0, len(v.anchor))
+	for id := range v.anchor {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var breaks []error
+	for _, id := range ids {
+		anchored, head := v.anchor[id], v.heads[id]
+		if head.Version >= anchored.Version {
+			continue
+		}
+
+		reason := fmt.Sprintf("events after version %d removed", head.Version)
+		if head.Version == 0 {
+			reason = "stream removed"
+		}
+		breaks = append(breaks, &ErrChainBroken{
+			StreamID: id,
+			Version:  anchored.Version,
+			Reason:   reason,
+		})
+	}
+
+	return breaks
+}
+
+// Anchor returns the heads of every stream seen so far, to check against later.
+func (v *ChainVerifier) Anchor() Anchor {
+	anchor := make(Anchor, len(v.heads))
+	for id, head := range v.heads {
+		anchor[id] = head
+	}
+
+	return anchor
+}
+
+// Legacy returns the number of events seen that were stored before events were
+// hashed.
+func (v *ChainVerifier) Legacy() int {
+	return v.legacy
+}
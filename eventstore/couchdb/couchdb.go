@@ -3,19 +3,23 @@ package couchdb
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"sort"
+	"time"
 
 	"github.com/ebittleman/voting/eventstore"
 	couchdb "github.com/fjl/go-couchdb"
 )
 
 const (
-	dbName   = "events"
-	pageSize = 250
+	dbName        = "events"
+	pageSize      = 250
+	positionDocID = "position"
+	// reservationTimeout is how long readers wait for an append to write the
+	// positions it reserved before reading past them.
+	reservationTimeout = time.Minute
 )
 
 var emptyObject = map[string]interface{}{}
@@ -26,19 +30,72 @@ type attachment struct {
 	Length      int64  `json:"length"`
 }
 
+// docWrapper is either an event stored as a document of its own, the way
+// events were written before appends were committed whole, or a commitDoc.
 type docWrapper struct {
 	ID          string                `json:"_id"`
 	Rev         string                `json:"_rev"`
 	Attachments map[string]attachment `json:"_attachments,omitempty"`
+	Events      eventstore.Events     `json:"events,omitempty"`
 	eventstore.Event
 }
 
+// commitDoc holds every event of an append, so they are written in a single
+// document write and readers see all of them or none. Its id is taken from the
+// first event, so two appends to the same version of a stream conflict.
+type commitDoc struct {
+	ID     string            `json:"_id"`
+	Events eventstore.Events `json:"events"`
+}
+
+// positionDoc holds the last position handed out to an event, and the
+// positions reserved by appends that haven't finished writing yet.
+type positionDoc struct {
+	ID       string        `json:"_id"`
+	Rev      string        `json:"_rev,omitempty"`
+	Position int64         `json:"position"`
+	Pending  []reservation `json:"pending,omitempty"`
+}
+
+// reservation is the first of the positions claimed by an unfinished append,
+// and when it claimed them.
+type reservation struct {
+	From     int64 `json:"from"`
+	Reserved int64 `json:"reserved"`
+}
+
+// row of a view over events. Index is where the event is in a commitDoc's
+// Events, and is null for an event stored as a document of its own.
 type row struct {
 	ID      string        `json:"id"`
 	Key     []interface{} `json:"key"`
+	Index   *int          `json:"value"`
 	Wrapper *docWrapper   `json:"doc,omitempty"`
 }
 
+// event returns the event the row was emitted for.
+func (r row) event() (eventstore.Event, bool) {
+	switch {
+	case r.Wrapper == nil:
+		return eventstore.Event{}, false
+	case r.Index == nil:
+		return r.Wrapper.Event, true
+	case *r.Index < 0 || *r.Index >= len(r.Wrapper.Events):
+		return eventstore.Event{}, false
+	}
+
+	return r.Wrapper.Events[*r.Index], true
+}
+
+// snapshotName is the attachment holding a snapshot taken at the row's event.
+func (r row) snapshotName(event eventstore.Event) string {
+	if r.Index == nil {
+		return "snapshot"
+	}
+
+	return fmt.Sprintf("snapshot-%d", event.Version)
+}
+
 type viewPage struct {
 	TotalRows int   `json:"total_rows"`
 	Offset    int   `json:"offset"`
@@ -53,6 +110,15 @@ type paginateViewInput struct {
 
 type paginateViewCallback func(*viewPage, bool) bool
 
+// database is the part of a couchdb database the store uses.
+type database interface {
+	Get(id string, doc interface{}, opts couchdb.Options) error
+	Put(id string, doc interface{}, rev string) (string, error)
+	View(ddoc, view string, result interface{}, opts couchdb.Options) error
+	Attachment(docid, name, rev string) (*couchdb.Attachment, error)
+	PutAttachment(docid string, att *couchdb.Attachment, rev string) (string, error)
+}
+
 // New initializes a new couchdb backed eventstore
 func New(client *couchdb.Client) (eventstore.EventStore, error) {
 	if err := client.Ping(); err != nil {
@@ -68,7 +134,7 @@ func New(client *couchdb.Client) (eventstore.EventStore, error) {
 
 type store struct {
 	client *couchdb.Client
-	db     *couchdb.DB
+	db     database
 }
 
 func (s *store) Refresh() error {
@@ -93,12 +159,13 @@ func (s *store) QueryByEventType(
 
 	if err = paginateView(s.db, input, func(page *viewPage, lastPage bool) bool {
 		for _, row := range page.Rows {
-			if row.Wrapper == nil {
+			event, ok := row.event()
+			if !ok {
 				log.Println("No Doc")
 				continue
 			}
 
-			events = append(events, row.Wrapper.Event)
+			events = append(events, event)
 		}
 		return true
 	}); err != nil {
@@ -109,6 +176,64 @@ func (s *store) QueryByEventType(
 	return events, nil
 }
 
+// QueryByFilter narrows the search with the by_type_and_time view when the
+// filter names event types, and otherwise walks every event in order. Stream
+// prefixes are matched client side.
+func (s *store) QueryByFilter(
+	filter eventstore.Filter,
+) (events eventstore.Events, err error) {
+	if len(filter.EventTypes) < 1 {
+		return s.collectMatches(s.IterateAll(0, 0), filter)
+	}
+
+	for _, eventType := range filter.Types() {
+		var to interface{} = emptyObject
+		if filter.To != 0 {
+			to = filter.To
+		}
+
+		matches, err := s.collectMatches(newViewIterator(s.db, &paginateViewInput{
+			DesignDoc: "_design/indexes",
+			View:      "by_type_and_time",
+			Options: couchdb.Options{
+				"reduce":        false,
+				"include_docs":  true,
+				"limit":         pageSize,
+				"inclusive_end": true,
+				"start_key":     []interface{}{eventType, filter.From},
+				"end_key":       []interface{}{eventType, to, emptyObject},
+			},
+		}, 0), filter)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, matches...)
+	}
+
+	sort.Sort(eventstore.ByPosition(events))
+	return events, nil
+}
+
+func (s *store) collectMatches(
+	it eventstore.Iterator,
+	filter eventstore.Filter,
+) (events eventstore.Events, err error) {
+	defer it.Close()
+
+	for it.Next() {
+		if event := it.Event(); filter.Match(event) {
+			events = append(events, event)
+		}
+	}
+
+	if err = it.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
 func (s *store) Query(
 	id string,
 ) (events eventstore.Events, err error) {
@@ -128,17 +253,18 @@ func (s *store) Query(
 
 	if err = paginateView(s.db, input, func(page *viewPage, lastPage bool) bool {
 		for _, row := range page.Rows {
-			if row.Wrapper == nil {
+			event, ok := row.event()
+			if !ok {
 				continue
 			}
 
-			done, resolveErr := resolveSnapshot(s.db, row.Wrapper)
+			done, resolveErr := resolveSnapshot(s.db, row, &event)
 			if resolveErr != nil {
 				fmt.Println("Error: Error Resolving Snapshot: ", resolveErr)
 				done = false
 			}
 
-			events = append(events, row.Wrapper.Event)
+			events = append(events, event)
 
 			if done {
 				return false
@@ -153,47 +279,361 @@ func (s *store) Query(
 	return events, nil
 }
 
+func (s *store) ReadAll(
+	position int64,
+	limit int,
+) (eventstore.Events, error) {
+	return eventstore.Collect(s.IterateAll(position, limit))
+}
+
+func (s *store) Iterate(
+	id string,
+	version int64,
+	limit int,
+) eventstore.Iterator {
+	return newViewIterator(s.db, &paginateViewInput{
+		DesignDoc: "_design/indexes",
+		View:      "events",
+		Options: couchdb.Options{
+			"reduce":        false,
+			"include_docs":  true,
+			"limit":         pageSize,
+			"inclusive_end": true,
+			"start_key":     []interface{}{id, version},
+			"end_key":       []interface{}{id, emptyObject},
+		},
+	}, limit)
+}
+
+// IterateAll stops before the positions of any append that is still being
+// written, so an event is never read ahead of one before it that is about to
+// appear. An append that hasn't finished within reservationTimeout is taken to
+// have failed, and is read past.
+func (s *store) IterateAll(
+	position int64,
+	limit int,
+) eventstore.Iterator {
+	end, err := s.committedPosition()
+
+	it := newViewIterator(s.db, &paginateViewInput{
+		DesignDoc: "_design/indexes",
+		View:      "by_position",
+		Options: couchdb.Options{
+			"reduce":        false,
+			"include_docs":  true,
+			"limit":         pageSize,
+			"inclusive_end": true,
+			"start_key":     []interface{}{position + 1},
+			"end_key":       []interface{}{end},
+		},
+	}, limit)
+	it.err = err
+	it.lastPage = end <= position
+
+	return it
+}
+
 func (s *store) Put(id string, version int64, event eventstore.Event) error {
-	if event.Version == version {
-		return errors.New("Conflict Error")
+	return s.Append(id, version, eventstore.Events{event})
+}
+
+func (s *store) Append(id string, version int64, events eventstore.Events) error {
+	if appended, err := s.appended(events); err != nil {
+		return err
+	} else if appended {
+		return nil
 	}
 
-	events, err := s.Query(id)
+	current, err := s.Query(id)
 	if err != nil {
 		return err
 	}
 
-	if num := len(events); num > 0 &&
-		(events[num-1].Version != version || event.Version < version) {
-		return fmt.Errorf("Conflict Error")
+	if num := len(current); num > 0 && current[num-1].Version != version {
+		return &eventstore.ErrConcurrencyConflict{
+			StreamID:        id,
+			ExpectedVersion: version,
+			ActualVersion:   current[num-1].Version,
+		}
+	}
+
+	expected := version
+	for _, event := range events {
+		if event.ID != id || event.Version != expected+1 {
+			return eventstore.ErrEventOutOfSequence
+		}
+		expected = event.Version
+	}
+
+	var previous string
+	if num := len(current); num > 0 {
+		previous = current[num-1].Hash
+	}
+
+	if err = eventstore.Chain(previous, events); err != nil {
+		return err
+	}
+
+	position, err := s.reservePositions(len(events))
+	if err != nil {
+		return err
+	}
+	defer s.releasePositions(position + 1)
+
+	for i := range events {
+		position++
+		events[i].Position = position
+	}
+
+	commit := commitDoc{
+		ID:     fmt.Sprintf("%s-%d", id, version+1),
+		Events: events,
+	}
+	if _, err = s.db.Put(commit.ID, commit, ""); couchdb.Conflict(err) {
+		return s.conflict(id, version)
 	}
 
-	docID := fmt.Sprintf("%s-%d", id, event.Version)
-	_, err = s.db.Put(docID, event, "")
 	return err
 }
 
+// appended looks events up by EventID rather than through Query, which stops at
+// the latest snapshot and could miss them.
+func (s *store) appended(events eventstore.Events) (bool, error) {
+	var stored eventstore.Events
+	for _, event := range events {
+		if event.EventID == "" {
+			return false, nil
+		}
+
+		page := new(viewPage)
+		if err := s.db.View("_design/indexes", "by_event_id", page, couchdb.Options{
+			"reduce":       false,
+			"include_docs": true,
+			"key":          []interface{}{event.EventID},
+		}); err != nil {
+			return false, err
+		}
+
+		for _, row := range page.Rows {
+			if event, ok := row.event(); ok {
+				stored = append(stored, event)
+			}
+		}
+	}
+
+	return eventstore.Appended(stored, events), nil
+}
+
+// reservePositions claims the next n positions and returns the position just
+// before them. Positions claimed by an append that later fails are never
+// reused, so the log can have gaps but never goes backwards. The claim stays
+// pending, holding readers back, until releasePositions is called.
+func (s *store) reservePositions(n int) (int64, error) {
+	for {
+		doc, err := s.positions()
+		if err != nil {
+			return 0, err
+		}
+
+		last := doc.Position
+		doc.Position += int64(n)
+		doc.Pending = append(pending(doc.Pending), reservation{
+			From:     last + 1,
+			Reserved: time.Now().UTC().Unix(),
+		})
+
+		_, err = s.db.Put(positionDocID, doc, doc.Rev)
+		if err == nil {
+			return last, nil
+		} else if !couchdb.Conflict(err) {
+			return 0, err
+		}
+	}
+}
+
+// releasePositions lets readers past the positions reserved from from on,
+// once they have been written or the append has failed.
+func (s *store) releasePositions(from int64) {
+	for {
+		doc, err := s.positions()
+		if err != nil {
+			log.Println("Error: Releasing positions: ", err)
+			return
+		}
+
+		var kept []reservation
+		for _, r := range pending(doc.Pending) {
+			if r.From != from {
+				kept = append(kept, r)
+			}
+		}
+		doc.Pending = kept
+
+		_, err = s.db.Put(positionDocID, doc, doc.Rev)
+		if err == nil {
+			return
+		} else if !couchdb.Conflict(err) {
+			log.Println("Error: Releasing positions: ", err)
+			return
+		}
+	}
+}
+
+// committedPosition is the last position readers can go up to without
+// skipping one that is still being written.
+func (s *store) committedPosition() (int64, error) {
+	doc, err := s.positions()
+	if err != nil {
+		return 0, err
+	}
+
+	committed := doc.Position
+	for _, r := range pending(doc.Pending) {
+		if r.From-1 < committed {
+			committed = r.From - 1
+		}
+	}
+
+	return committed, nil
+}
+
+func (s *store) positions() (*positionDoc, error) {
+	doc := new(positionDoc)
+	if err := s.db.Get(positionDocID, doc, nil); err != nil &&
+		!couchdb.NotFound(err) {
+		return nil, err
+	}
+	doc.ID = positionDocID
+
+	return doc, nil
+}
+
+// pending drops the reservations that have been held for longer than
+// reservationTimeout.
+func pending(reservations []reservation) []reservation {
+	var (
+		expired = time.Now().UTC().Add(-reservationTimeout).Unix()
+		kept    []reservation
+	)
+
+	for _, r := range reservations {
+		if r.Reserved > expired {
+			kept = append(kept, r)
+		}
+	}
+
+	return kept
+}
+
+// BackfillPositions gives the events stored before events had positions one
+// each, ordered by when they happened, after every event that already has
+// one. Run it before anything new is appended to the store, so the old events
+// come ahead of the new ones. Returns how many events it updated.
+func BackfillPositions(client *couchdb.Client) (int, error) {
+	s := &store{client: client, db: client.DB(dbName)}
+
+	var count int
+	for {
+		page := new(viewPage)
+		if err := s.db.View("_design/indexes", "without_position", page, couchdb.Options{
+			"reduce":       false,
+			"include_docs": true,
+			"limit":        pageSize,
+		}); err != nil {
+			return count, err
+		}
+
+		if len(page.Rows) < 1 {
+			return count, nil
+		}
+
+		written, err := s.backfill(page.Rows)
+		count += written
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+// backfill positions the events in rows, in order. An event changed since it
+// was read is left for the next page.
+func (s *store) backfill(rows []row) (int, error) {
+	position, err := s.reservePositions(len(rows))
+	if err != nil {
+		return 0, err
+	}
+	defer s.releasePositions(position + 1)
+
+	var written int
+	for _, row := range rows {
+		position++
+		if row.Wrapper == nil {
+			continue
+		}
+
+		row.Wrapper.Position = position
+		_, err = s.db.Put(row.Wrapper.ID, row.Wrapper, row.Wrapper.Rev)
+		if couchdb.Conflict(err) {
+			continue
+		} else if err != nil {
+			return written, err
+		}
+		written++
+	}
+
+	return written, nil
+}
+
+// conflict reloads the stream so the returned error reports the version that
+// beat us.
+func (s *store) conflict(id string, version int64) error {
+	events, err := s.Query(id)
+	if err != nil {
+		return err
+	}
+
+	var actual int64
+	if num := len(events); num > 0 {
+		actual = events[num-1].Version
+	}
+
+	return &eventstore.ErrConcurrencyConflict{
+		StreamID:        id,
+		ExpectedVersion: version,
+		ActualVersion:   actual,
+	}
+}
+
 func (s *store) Snapshot(event eventstore.Event, snapshot interface{}) error {
 	data, err := json.Marshal(snapshot)
 	if err != nil {
 		return err
 	}
 
-	docID := fmt.Sprintf("%s-%d", event.ID, event.Version)
-	wrapper := new(docWrapper)
-	if err = s.db.Get(docID, wrapper, nil); err != nil {
+	page := new(viewPage)
+	if err = s.db.View("_design/indexes", "events", page, couchdb.Options{
+		"reduce":       false,
+		"include_docs": true,
+		"key":          []interface{}{event.ID, event.Version},
+	}); err != nil {
 		return err
 	}
+
+	if len(page.Rows) < 1 || page.Rows[0].Wrapper == nil {
+		return fmt.Errorf("Event %s-%d Not Found", event.ID, event.Version)
+	}
+	row := page.Rows[0]
+
 	att := new(couchdb.Attachment)
 	att.Body = bytes.NewBuffer(data)
 	att.Type = "application/json"
-	att.Name = "snapshot"
-	_, err = s.db.PutAttachment(wrapper.ID, att, wrapper.Rev)
+	att.Name = row.snapshotName(event)
+	_, err = s.db.PutAttachment(row.Wrapper.ID, att, row.Wrapper.Rev)
 	return err
 }
 
 func paginateView(
-	db *couchdb.DB,
+	db database,
 	input *paginateViewInput,
 	callback paginateViewCallback,
 ) (err error) {
@@ -213,22 +653,21 @@ func paginateView(
 	}
 }
 
-func resolveSnapshot(db *couchdb.DB, wrapper *docWrapper) (bool, error) {
-	if wrapper.Attachments == nil {
-		return false, nil
-	}
-
-	if _, ok := wrapper.Attachments["snapshot"]; !ok {
+// resolveSnapshot sets the Snapshot of the row's event when one was taken at
+// it, reporting whether there was one.
+func resolveSnapshot(
+	db database,
+	r row,
+	event *eventstore.Event,
+) (bool, error) {
+	name := r.snapshotName(*event)
+	if _, ok := r.Wrapper.Attachments[name]; !ok {
 		return false, nil
 	}
 
 	log.Println("Attempting to load from snapshot")
 
-	attachment, err := db.Attachment(
-		wrapper.ID,
-		"snapshot",
-		wrapper.Rev,
-	)
+	attachment, err := db.Attachment(r.Wrapper.ID, name, r.Wrapper.Rev)
 	if err != nil {
 		return false, err
 	}
@@ -241,7 +680,7 @@ func resolveSnapshot(db *couchdb.DB, wrapper *docWrapper) (bool, error) {
 	log.Println("Snapdata: ", string(data))
 
 	raw := json.RawMessage(data)
-	wrapper.Event.Snapshot = &raw
+	event.Snapshot = &raw
 
 	return true, nil
 }
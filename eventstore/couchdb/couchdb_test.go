@@ -0,0 +1,412 @@
+package couchdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ebittleman/voting/eventstore"
+	couchdb "github.com/fjl/go-couchdb"
+)
+
+func TestAppendWritesBatchInOneDocument(t *testing.T) {
+	db := newFakeDB()
+	store := &store{db: db}
+
+	// the process dies as soon as an append writes a second document
+	var writes int
+	db.beforePut = func(id string) error {
+		if id == positionDocID {
+			return nil
+		}
+		if writes++; writes > 1 {
+			return fmt.Errorf("killed writing %s", id)
+		}
+		return nil
+	}
+
+	if err := store.Append("s", 0, newEvents("s", 1, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := store.Query("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected: 3 event(s), Got: %d event(s)", len(events))
+	}
+
+	// killed before the next batch's document is written, none of it is seen
+	if err = store.Append("s", 3, newEvents("s", 4, 2)); err == nil {
+		t.Fatal("Expected an Error")
+	}
+
+	if events, _ = store.Query("s"); len(events) != 3 {
+		t.Fatalf("Expected: 3 event(s), Got: %d event(s)", len(events))
+	}
+	if all, _ := store.ReadAll(0, 0); len(all) != 3 {
+		t.Fatalf("Expected: 3 event(s), Got: %d event(s)", len(all))
+	}
+}
+
+func TestAppendConflictsWithBatchAtSameVersion(t *testing.T) {
+	store := &store{db: newFakeDB()}
+
+	if err := store.Append("s", 0, newEvents("s", 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.Append("s", 0, newEvents("s", 1, 1))
+	conflict, ok := err.(*eventstore.ErrConcurrencyConflict)
+	if !ok || conflict.ActualVersion != 2 {
+		t.Fatalf("Expected a conflict at version 2, Got: %v", err)
+	}
+
+	if err = store.Append("s", 2, newEvents("s", 3, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := store.Query("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, event := range events {
+		if event.Version != int64(i+1) || event.Position != int64(i+1) {
+			t.Fatalf("Expected version and position %d, Got: %v", i+1, event)
+		}
+	}
+}
+
+func TestQueryResolvesSnapshotInBatch(t *testing.T) {
+	store := &store{db: newFakeDB()}
+
+	events := newEvents("s", 1, 3)
+	if err := store.Append("s", 0, events); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Snapshot(events[1], map[string]int{"count": 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	queried, err := store.Query("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queried) != 2 || queried[0].Snapshot == nil {
+		t.Fatalf("Expected events from the snapshot on, Got: %v", queried)
+	}
+	if string(*queried[0].Snapshot) != `{"count":2}` {
+		t.Fatalf("Expected: %s, Got: %s", `{"count":2}`, *queried[0].Snapshot)
+	}
+}
+
+func TestQueryByFilterWithRepeatedTypes(t *testing.T) {
+	store := &store{db: newFakeDB()}
+
+	if err := store.Append("s", 0, newEvents("s", 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := store.QueryByFilter(eventstore.Filter{
+		EventTypes: []string{"testEvent", "testEvent"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 || events[0].Position != 1 || events[1].Position != 2 {
+		t.Fatalf("Expected: positions [1 2], Got: %v", events)
+	}
+}
+
+func newEvents(id string, from int64, n int) eventstore.Events {
+	var events eventstore.Events
+	for i := 0; i < n; i++ {
+		events = append(events, eventstore.Event{
+			ID:      id,
+			Version: from + int64(i),
+			Type:    "testEvent",
+		})
+	}
+
+	return events
+}
+
+// fakeDB keeps documents in memory and answers the store's views the way the
+// design document installed by votingadm does.
+type fakeDB struct {
+	docs        map[string]map[string]interface{}
+	attachments map[string][]byte
+	beforePut   func(id string) error
+	sync.Mutex
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		docs:        make(map[string]map[string]interface{}),
+		attachments: make(map[string][]byte),
+	}
+}
+
+func (f *fakeDB) Get(id string, doc interface{}, opts couchdb.Options) error {
+	f.Lock()
+	defer f.Unlock()
+
+	stored, ok := f.docs[id]
+	if !ok {
+		return &couchdb.Error{StatusCode: http.StatusNotFound}
+	}
+
+	return convert(stored, doc)
+}
+
+func (f *fakeDB) Put(id string, doc interface{}, rev string) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.beforePut != nil {
+		if err := f.beforePut(id); err != nil {
+			return "", err
+		}
+	}
+
+	stored := make(map[string]interface{})
+	if err := convert(doc, &stored); err != nil {
+		return "", err
+	}
+
+	return f.put(id, stored, rev)
+}
+
+func (f *fakeDB) put(
+	id string,
+	doc map[string]interface{},
+	rev string,
+) (string, error) {
+	var generation int
+	if current, ok := f.docs[id]; ok {
+		if current["_rev"] != rev {
+			return "", &couchdb.Error{StatusCode: http.StatusConflict}
+		}
+		fmt.Sscanf(rev, "%d-", &generation)
+	} else if rev != "" {
+		return "", &couchdb.Error{StatusCode: http.StatusConflict}
+	}
+
+	doc["_id"] = id
+	doc["_rev"] = fmt.Sprintf("%d-fake", generation+1)
+	f.docs[id] = doc
+
+	return doc["_rev"].(string), nil
+}
+
+func (f *fakeDB) Attachment(docid, name, rev string) (*couchdb.Attachment, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	data, ok := f.attachments[docid+"/"+name]
+	if !ok {
+		return nil, &couchdb.Error{StatusCode: http.StatusNotFound}
+	}
+
+	return &couchdb.Attachment{Name: name, Body: bytes.NewReader(data)}, nil
+}
+
+func (f *fakeDB) PutAttachment(
+	docid string,
+	att *couchdb.Attachment,
+	rev string,
+) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	data, err := ioutil.ReadAll(att.Body)
+	if err != nil {
+		return "", err
+	}
+
+	doc, ok := f.docs[docid]
+	if !ok {
+		return "", &couchdb.Error{StatusCode: http.StatusNotFound}
+	}
+
+	attachments, _ := doc["_attachments"].(map[string]interface{})
+	if attachments == nil {
+		attachments = make(map[string]interface{})
+	}
+	attachments[att.Name] = map[string]interface{}{
+		"stub":         true,
+		"content_type": att.Type,
+		"length":       len(data),
+	}
+	doc["_attachments"] = attachments
+
+	f.attachments[docid+"/"+att.Name] = data
+	return f.put(docid, doc, rev)
+}
+
+func (f *fakeDB) View(
+	ddoc, view string,
+	result interface{},
+	opts couchdb.Options,
+) error {
+	f.Lock()
+	defer f.Unlock()
+
+	var rows []map[string]interface{}
+	for id, doc := range f.docs {
+		emit := func(event map[string]interface{}, value interface{}) {
+			key, ok := viewKey(view, event)
+			if !ok {
+				return
+			}
+			rows = append(rows, map[string]interface{}{
+				"id": id, "key": key, "value": value, "doc": doc,
+			})
+		}
+
+		if _, ok := doc["type"]; ok {
+			emit(doc, nil)
+		}
+		events, _ := doc["events"].([]interface{})
+		for i, event := range events {
+			emit(event.(map[string]interface{}), i)
+		}
+	}
+
+	var options map[string]interface{}
+	if err := convert(opts, &options); err != nil {
+		return err
+	}
+
+	descending, _ := options["descending"].(bool)
+	sort.Slice(rows, func(i, j int) bool {
+		less := collate(rows[i]["key"], rows[j]["key"]) < 0
+		if descending {
+			return collate(rows[i]["key"], rows[j]["key"]) > 0
+		}
+		return less
+	})
+
+	var page []map[string]interface{}
+	for _, row := range rows {
+		if key, ok := options["key"]; ok && collate(row["key"], key) != 0 {
+			continue
+		}
+
+		if start, ok := options["start_key"]; ok {
+			c := collate(row["key"], start)
+			if (!descending && c < 0) || (descending && c > 0) {
+				continue
+			}
+		}
+
+		if end, ok := options["end_key"]; ok {
+			c := collate(row["key"], end)
+			if (!descending && c > 0) || (descending && c < 0) {
+				continue
+			}
+		}
+
+		page = append(page, row)
+	}
+
+	if skip, ok := options["skip"].(float64); ok {
+		if int(skip) > len(page) {
+			skip = float64(len(page))
+		}
+		page = page[int(skip):]
+	}
+
+	if limit, ok := options["limit"].(float64); ok && int(limit) < len(page) {
+		page = page[:int(limit)]
+	}
+
+	return convert(map[string]interface{}{"rows": page}, result)
+}
+
+// viewKey is the key the design document's view emits for event.
+func viewKey(view string, e map[string]interface{}) ([]interface{}, bool) {
+	switch view {
+	case "events":
+		return []interface{}{e["id"], e["version"]}, true
+	case "by_type":
+		return []interface{}{e["type"], e["id"], e["version"]}, true
+	case "by_position":
+		return []interface{}{e["position"]}, true
+	case "by_type_and_time":
+		return []interface{}{e["type"], e["timestamp"], e["position"]}, true
+	case "by_event_id":
+		return []interface{}{e["event_id"]}, e["event_id"] != nil
+	}
+
+	return nil, false
+}
+
+// collate orders keys the way couchdb does: null, booleans, numbers, strings,
+// arrays and then objects.
+func collate(a, b interface{}) int {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case bool:
+			return 1
+		case float64:
+			return 2
+		case string:
+			return 3
+		case []interface{}:
+			return 4
+		}
+		return 5
+	}
+
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+
+	switch a := a.(type) {
+	case float64:
+		switch bf := b.(float64); {
+		case a < bf:
+			return -1
+		case a > bf:
+			return 1
+		}
+	case string:
+		switch bs := b.(string); {
+		case a < bs:
+			return -1
+		case a > bs:
+			return 1
+		}
+	case []interface{}:
+		bs := b.([]interface{})
+		for i := 0; i < len(a) && i < len(bs); i++ {
+			if c := collate(a[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(bs)
+	}
+
+	return 0
+}
+
+// convert copies v into out through json, as couchdb would.
+func convert(v interface{}, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,87 @@
+package couchdb
+
+import "github.com/ebittleman/voting/eventstore"
+
+// viewIterator reads a view one page at a time, only fetching the next page
+// once the rows of the current one have been handed out.
+type viewIterator struct {
+	db       database
+	input    *paginateViewInput
+	limit    int
+	count    int
+	rows     []row
+	lastPage bool
+	event    eventstore.Event
+	err      error
+}
+
+func newViewIterator(
+	db database,
+	input *paginateViewInput,
+	limit int,
+) *viewIterator {
+	if limit > 0 && limit < input.Options["limit"].(int) {
+		input.Options["limit"] = limit
+	}
+
+	return &viewIterator{
+		db:    db,
+		input: input,
+		limit: limit,
+	}
+}
+
+func (v *viewIterator) Next() bool {
+	for v.err == nil && (v.limit < 1 || v.count < v.limit) {
+		if len(v.rows) < 1 {
+			if v.lastPage || !v.fetch() {
+				return false
+			}
+			continue
+		}
+
+		row := v.rows[0]
+		v.rows = v.rows[1:]
+		event, ok := row.event()
+		if !ok {
+			continue
+		}
+
+		v.event = event
+		v.count++
+		return true
+	}
+
+	return false
+}
+
+func (v *viewIterator) fetch() bool {
+	page := new(viewPage)
+	options := v.input.Options
+	if v.err = v.db.View(v.input.DesignDoc, v.input.View, page, options); v.err != nil {
+		return false
+	}
+
+	v.rows = page.Rows
+	v.lastPage = len(page.Rows) < options["limit"].(int)
+	if len(page.Rows) > 0 {
+		options["start_key"] = page.Rows[len(page.Rows)-1].Key
+		options["skip"] = 1
+	}
+
+	return true
+}
+
+func (v *viewIterator) Event() eventstore.Event {
+	return v.event
+}
+
+func (v *viewIterator) Err() error {
+	return v.err
+}
+
+func (v *viewIterator) Close() error {
+	v.rows = nil
+	v.lastPage = true
+	return nil
+}
@@ -1,15 +1,115 @@
 package eventstore
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
 
-// Event an event store record
+var (
+	// ErrEventOutOfSequence returned when events passed to Append do not belong
+	// to the stream or do not directly follow each other.
+	ErrEventOutOfSequence = errors.New("Event out of sequence")
+)
+
+// ErrConcurrencyConflict returned when a stream has moved past the version a
+// writer expected it to be at.
+type ErrConcurrencyConflict struct {
+	StreamID        string
+	ExpectedVersion int64
+	ActualVersion   int64
+}
+
+func (e *ErrConcurrencyConflict) Error() string {
+	return fmt.Sprintf(
+		"Conflict Error: stream %s expected version %d, actual version %d",
+		e.StreamID,
+		e.ExpectedVersion,
+		e.ActualVersion,
+	)
+}
+
+// IsConcurrencyConflict reports whether err is, or wraps, an
+// *ErrConcurrencyConflict
+func IsConcurrencyConflict(err error) bool {
+	var conflict *ErrConcurrencyConflict
+	return errors.As(err, &conflict)
+}
+
+// Event an event store record. ID is the id of the stream the event belongs
+// to, while EventID identifies the event itself. Position orders events across
+// every stream in the store and is assigned by the store when the event is
+// appended. SchemaVersion is the version of the shape of Data, see Upcasters.
+// Hash is also set by the store and chains the event to the one before it, see
+// ChainHash.
 type Event struct {
-	ID        string           `json:"id"`
-	Version   int64            `json:"version"`
-	Type      string           `json:"type"`
-	Timestamp int64            `json:"timestamp"`
-	Data      *json.RawMessage `json:"data,omitempty"`
-	Snapshot  *json.RawMessage `json:"snapshot,omitempty"`
+	ID            string           `json:"id"`
+	EventID       string           `json:"event_id,omitempty"`
+	Version       int64            `json:"version"`
+	Position      int64            `json:"position,omitempty"`
+	Type          string           `json:"type"`
+	SchemaVersion int              `json:"schema_version,omitempty"`
+	Timestamp     int64            `json:"timestamp"`
+	Data          *json.RawMessage `json:"data,omitempty"`
+	Snapshot      *json.RawMessage `json:"snapshot,omitempty"`
+	Metadata      Metadata         `json:"metadata,omitempty"`
+	Hash          string           `json:"hash,omitempty"`
+}
+
+// Metadata keys with first class accessors on Metadata
+const (
+	CorrelationIDKey = "correlation_id"
+	CausationIDKey   = "causation_id"
+	ActorKey         = "actor"
+)
+
+// Metadata describes where an event came from. CorrelationID is shared by
+// everything done on behalf of one request, CausationID is the id of the
+// command or event that directly caused this one and Actor is who asked for it.
+type Metadata map[string]string
+
+// NewMetadata creates Metadata with the first class fields filled in, skipping
+// any that are empty.
+func NewMetadata(correlationID, causationID, actor string) Metadata {
+	metadata := make(Metadata)
+	metadata.set(CorrelationIDKey, correlationID)
+	metadata.set(CausationIDKey, causationID)
+	metadata.set(ActorKey, actor)
+	return metadata
+}
+
+// CorrelationID of the request that led to the event
+func (m Metadata) CorrelationID() string {
+	return m[CorrelationIDKey]
+}
+
+// CausationID of the command or event that caused the event
+func (m Metadata) CausationID() string {
+	return m[CausationIDKey]
+}
+
+// Actor who caused the event
+func (m Metadata) Actor() string {
+	return m[ActorKey]
+}
+
+// Copy returns a copy that can be changed without affecting m
+func (m Metadata) Copy() Metadata {
+	if m == nil {
+		return nil
+	}
+
+	dst := make(Metadata, len(m))
+	for key, value := range m {
+		dst[key] = value
+	}
+	return dst
+}
+
+func (m Metadata) set(key, value string) {
+	if value != "" {
+		m[key] = value
+	}
 }
 
 // Events list of Event
@@ -29,11 +129,67 @@ func (e Events) Less(i, j int) bool {
 	return e[i].ID < e[j].ID
 }
 
+// Appended reports whether every one of events is already in stored, matched
+// by EventID, in which case appending them again should be treated as a
+// success. The stored positions are copied onto events.
+func Appended(stored Events, events Events) bool {
+	if len(events) < 1 {
+		return false
+	}
+
+	byEventID := make(map[string]Event, len(stored))
+	for _, event := range stored {
+		if event.EventID != "" {
+			byEventID[event.EventID] = event
+		}
+	}
+
+	for _, event := range events {
+		if _, ok := byEventID[event.EventID]; !ok || event.EventID == "" {
+			return false
+		}
+	}
+
+	for i := range events {
+		events[i].Position = byEventID[events[i].EventID].Position
+	}
+
+	return true
+}
+
+// ByPosition sorts Events in the order they were appended to the store.
+type ByPosition Events
+
+func (e ByPosition) Len() int {
+	return len(e)
+}
+func (e ByPosition) Swap(i, j int) {
+	e[i], e[j] = e[j], e[i]
+}
+func (e ByPosition) Less(i, j int) bool {
+	return e[i].Position < e[j].Position
+}
+
 // EventStore component that manages system events.
 type EventStore interface {
 	Query(string) (Events, error)
 	QueryByEventType(string) (Events, error)
+	// QueryByFilter returns every event matched by a Filter in the order they
+	// were appended.
+	QueryByFilter(Filter) (Events, error)
+	// ReadAll returns up to limit events, across all streams, appended after
+	// position. A limit less than 1 returns them all.
+	ReadAll(position int64, limit int) (Events, error)
+	// Iterate lazily yields up to limit events of a stream from version on,
+	// without resolving snapshots.
+	Iterate(id string, version int64, limit int) Iterator
+	// IterateAll lazily yields what ReadAll would return.
+	IterateAll(position int64, limit int) Iterator
 	Put(string, int64, Event) error
+	// Append writes all of events to the stream or none of them, expecting it
+	// to be at version, and sets each event's Position. Appending events whose
+	// EventIDs are already in the stream succeeds without writing anything.
+	Append(id string, version int64, events Events) error
 	Snapshot(Event, interface{}) error
 	Refresh() error
 }
@@ -1 +1,207 @@
 package eventstore
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUpcastChain(t *testing.T) {
+	upcasters := NewUpcasters()
+	if version := upcasters.Version("Renamed"); version != 1 {
+		t.Fatalf("Expected: version 1, Got: version %d", version)
+	}
+
+	rename := func(from, to string) Upcaster {
+		return func(data *json.RawMessage) (*json.RawMessage, error) {
+			fields := make(map[string]interface{})
+			if err := json.Unmarshal(*data, &fields); err != nil {
+				return nil, err
+			}
+
+			fields[to] = fields[from]
+			delete(fields, from)
+
+			bytes, err := json.Marshal(fields)
+			raw := json.RawMessage(bytes)
+			return &raw, err
+		}
+	}
+	upcasters.Register("Renamed", 1, rename("a", "b"))
+	upcasters.Register("Renamed", 2, rename("b", "c"))
+
+	if version := upcasters.Version("Renamed"); version != 3 {
+		t.Fatalf("Expected: version 3, Got: version %d", version)
+	}
+
+	tests := []struct {
+		schemaVersion int
+		data          string
+	}{
+		{0, `{"a":"value"}`},
+		{2, `{"b":"value"}`},
+		{3, `{"c":"value"}`},
+	}
+
+	for _, test := range tests {
+		data := json.RawMessage(test.data)
+		event, err := upcasters.Upcast(Event{
+			Type:          "Renamed",
+			SchemaVersion: test.schemaVersion,
+			Data:          &data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if event.SchemaVersion != 3 || string(*event.Data) != `{"c":"value"}` {
+			t.Fatalf("From version %d, Got: version %d %s",
+				test.schemaVersion, event.SchemaVersion, *event.Data)
+		}
+	}
+}
+
+func TestChainVerifier(t *testing.T) {
+	var events Events
+	for version := int64(1); version <= 4; version++ {
+		data := json.RawMessage(`{"choice":1}`)
+		events = append(events, Event{
+			ID:      "poll",
+			Version: version,
+			Type:    "BallotCast",
+			Data:    &data,
+		})
+	}
+
+	if err := Chain("", events); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := NewChainVerifier(nil)
+	for _, event := range events {
+		if err := verifier.Check(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tampered := json.RawMessage(`{"choice":2}`)
+	altered := append(Events{}, events...)
+	altered[1].Data = &tampered
+
+	removed := append(Events{events[0]}, events[2:]...)
+
+	for name, stream := range map[string]Events{
+		"altered": altered,
+		"removed": removed,
+	} {
+		verifier := NewChainVerifier(nil)
+		breaks := 0
+		for _, event := range stream {
+			if err := verifier.Check(event); err != nil {
+				if _, ok := err.(*ErrChainBroken); !ok {
+					t.Fatal(err)
+				}
+				breaks++
+			}
+		}
+
+		if breaks != 1 {
+			t.Fatalf("%s: Expected: 1 break, Got: %d break(s)", name, breaks)
+		}
+	}
+}
+
+func TestChainVerifierAnchor(t *testing.T) {
+	var events Events
+	for _, id := range []string{"poll1", "poll2"} {
+		var stream Events
+		for version := int64(1); version <= 3; version++ {
+			stream = append(stream, Event{
+				ID:      id,
+				Version: version,
+				Type:    "BallotCast",
+			})
+		}
+
+		if err := Chain("", stream); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, stream...)
+	}
+
+	verifier := NewChainVerifier(nil)
+	for _, event := range events {
+		if err := verifier.Check(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	anchor := verifier.Anchor()
+
+	for name, expected := range map[string]struct {
+		events Events
+		reason string
+	}{
+		"intact":    {events, ""},
+		"truncated": {events[:5], "events after version 2 removed"},
+		"removed":   {events[:3], "stream removed"},
+	} {
+		verifier := NewChainVerifier(anchor)
+		for _, event := range expected.events {
+			if err := verifier.Check(event); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		breaks := verifier.Finish()
+		if expected.reason == "" {
+			if len(breaks) != 0 {
+				t.Fatalf("%s: Expected no breaks, Got: %v", name, breaks)
+			}
+			continue
+		}
+
+		if len(breaks) != 1 {
+			t.Fatalf("%s: Expected: 1 break, Got: %v", name, breaks)
+		}
+		broken, ok := breaks[0].(*ErrChainBroken)
+		if !ok || broken.StreamID != "poll2" || broken.Reason != expected.reason {
+			t.Fatalf("%s: Expected: %s, Got: %v", name, expected.reason, breaks[0])
+		}
+	}
+}
+
+func TestChainVerifierLegacyPrefix(t *testing.T) {
+	events := Events{
+		Event{ID: "poll", Version: 1, Type: "PollCreated"},
+		Event{ID: "poll", Version: 2, Type: "PollOpened"},
+		Event{ID: "poll", Version: 3, Type: "BallotCast"},
+		Event{ID: "poll", Version: 4, Type: "BallotCast"},
+	}
+	if err := Chain("", events[2:]); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := NewChainVerifier(nil)
+	for _, event := range events {
+		if err := verifier.Check(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if legacy := verifier.Legacy(); legacy != 2 {
+		t.Fatalf("Expected: 2 legacy event(s), Got: %d", legacy)
+	}
+
+	// a hash stripped once the stream is hashed is still a break.
+	stripped := append(Events{}, events...)
+	stripped[3].Hash = ""
+
+	verifier = NewChainVerifier(nil)
+	breaks := 0
+	for _, event := range stripped {
+		if err := verifier.Check(event); err != nil {
+			breaks++
+		}
+	}
+	if breaks != 1 {
+		t.Fatalf("Expected: 1 break, Got: %d break(s)", breaks)
+	}
+}
@@ -0,0 +1,59 @@
+package eventstore
+
+import "strings"
+
+// Filter selects events across every stream in a store. Zero values match
+// everything: no EventTypes matches every type, a zero From or To leaves that
+// end of the timestamp range open and an empty StreamPrefix matches every
+// stream.
+type Filter struct {
+	EventTypes   []string
+	From         int64
+	To           int64
+	StreamPrefix string
+}
+
+// Match reports whether an event is selected by the filter. From and To are
+// both inclusive.
+func (f Filter) Match(event Event) bool {
+	if len(f.EventTypes) > 0 {
+		found := false
+		for _, eventType := range f.EventTypes {
+			if eventType == event.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.From != 0 && event.Timestamp < f.From {
+		return false
+	}
+
+	if f.To != 0 && event.Timestamp > f.To {
+		return false
+	}
+
+	return strings.HasPrefix(event.ID, f.StreamPrefix)
+}
+
+// Types returns the filter's EventTypes with any repeats left out, so a store
+// looking events up by type finds each of them once.
+func (f Filter) Types() []string {
+	var (
+		types = make([]string, 0, len(f.EventTypes))
+		seen  = make(map[string]bool, len(f.EventTypes))
+	)
+
+	for _, eventType := range f.EventTypes {
+		if !seen[eventType] {
+			seen[eventType] = true
+			types = append(types, eventType)
+		}
+	}
+
+	return types
+}
@@ -0,0 +1,68 @@
+package eventstore
+
+import "io"
+
+// Iterator yields events one at a time so a caller never has to hold a whole
+// stream in memory. Call Next before each Event, and check Err once Next
+// returns false.
+//
+//	for it.Next() {
+//		event := it.Event()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type Iterator interface {
+	Next() bool
+	Event() Event
+	Err() error
+	io.Closer
+}
+
+// Collect reads the rest of an iterator into memory and closes it.
+func Collect(it Iterator) (events Events, err error) {
+	defer it.Close()
+
+	for it.Next() {
+		events = append(events, it.Event())
+	}
+
+	if err = it.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// QueryUntil returns a stream's events up to and including version and
+// timestamp, reading no further than it has to. A zero version or timestamp
+// leaves that bound open. Snapshots are not resolved, so the whole history up
+// to that point is returned.
+func QueryUntil(
+	store EventStore,
+	id string,
+	version int64,
+	timestamp int64,
+) (Events, error) {
+	var events Events
+
+	it := store.Iterate(id, 1, 0)
+	defer it.Close()
+
+	for it.Next() {
+		event := it.Event()
+		if version != 0 && event.Version > version {
+			break
+		}
+		if timestamp != 0 && event.Timestamp > timestamp {
+			break
+		}
+
+		events = append(events, event)
+	}
+
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,131 @@
+package json
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/ebittleman/voting/eventstore"
+)
+
+// tableIterator unmarshals records one at a time as they are asked for. It
+// walks the records the table held when it was created; later appends are not
+// seen.
+type tableIterator struct {
+	records []json.RawMessage
+	match   func(*eventstore.Event) bool
+	limit   int
+	count   int
+	event   eventstore.Event
+	err     error
+}
+
+// iterate walks every record in the table. A nil match matches everything.
+func (t *table) iterate(
+	limit int,
+	match func(*eventstore.Event) bool,
+) *tableIterator {
+	t.RLock()
+	defer t.RUnlock()
+
+	return &tableIterator{
+		records: t.records,
+		match:   match,
+		limit:   limit,
+	}
+}
+
+// iterateFrom finds where to start with a binary search on positions.
+func (t *table) iterateFrom(position int64, limit int) *tableIterator {
+	t.RLock()
+	defer t.RUnlock()
+
+	start := sort.Search(len(t.positions), func(i int) bool {
+		return t.positions[i] > position
+	})
+
+	return &tableIterator{
+		records: t.records[start:],
+		limit:   limit,
+	}
+}
+
+func (t *table) iterateStream(
+	id string,
+	limit int,
+	match func(*eventstore.Event) bool,
+) *tableIterator {
+	t.RLock()
+	defer t.RUnlock()
+
+	return &tableIterator{
+		records: t.subset(t.streams[id]),
+		match:   match,
+		limit:   limit,
+	}
+}
+
+// iterateTypes walks the records of several event types, merged back into the
+// order they were appended.
+func (t *table) iterateTypes(
+	eventTypes []string,
+	limit int,
+	match func(*eventstore.Event) bool,
+) *tableIterator {
+	t.RLock()
+	defer t.RUnlock()
+
+	var indexes []int
+	for _, eventType := range eventTypes {
+		indexes = append(indexes, t.types[eventType]...)
+	}
+	sort.Ints(indexes)
+
+	return &tableIterator{
+		records: t.subset(indexes),
+		match:   match,
+		limit:   limit,
+	}
+}
+
+// subset must be called with the table locked.
+func (t *table) subset(indexes []int) []json.RawMessage {
+	records := make([]json.RawMessage, len(indexes))
+	for i, index := range indexes {
+		records[i] = t.records[index]
+	}
+	return records
+}
+
+func (i *tableIterator) Next() bool {
+	for i.err == nil && len(i.records) > 0 &&
+		(i.limit < 1 || i.count < i.limit) {
+		event := new(eventstore.Event)
+		if i.err = json.Unmarshal(i.records[0], event); i.err != nil {
+			return false
+		}
+		i.records = i.records[1:]
+
+		if i.match != nil && !i.match(event) {
+			continue
+		}
+
+		i.event = *event
+		i.count++
+		return true
+	}
+
+	return false
+}
+
+func (i *tableIterator) Event() eventstore.Event {
+	return i.event
+}
+
+func (i *tableIterator) Err() error {
+	return i.err
+}
+
+func (i *tableIterator) Close() error {
+	i.records = nil
+	return nil
+}
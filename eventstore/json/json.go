@@ -3,8 +3,10 @@ package json
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
+	"log"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	jsondb "github.com/ebittleman/voting/database/json"
@@ -13,50 +15,69 @@ import (
 
 const tableName = "events"
 
+var (
+	// errExpectedSnapshot returned when an invalid type is passed to the
+	// snapshots table
+	errExpectedSnapshot = errors.New("Expected snapshot")
+	// errAppendOnly returned when deleting from the events table
+	errAppendOnly = errors.New("Events can't be deleted")
+)
+
 // New creates a json backed event store
 func New(conn *jsondb.Connection) (eventstore.EventStore, error) {
-	table := new(table)
+	table := newTable()
 	if err := conn.RegisterTable(tableName, table); err != nil {
 		return nil, err
 	}
 
+	snapshots := newSnapshotTable()
+	if err := conn.RegisterTable(snapshotTableName, snapshots); err != nil {
+		conn.UnregisterTable(tableName)
+		return nil, err
+	}
+
 	store := new(store)
 	store.conn = conn
 	store.table = table
+	store.snapshots = snapshots
 
 	return store, nil
 }
 
 type store struct {
-	conn  *jsondb.Connection
-	table *table
+	conn      *jsondb.Connection
+	table     *table
+	snapshots *snapshotTable
+	sync.Mutex
 }
 
+// Refresh picks up events and snapshots another process appended to the files
+// since they were last read, or reads them all again when they were replaced.
 func (s *store) Refresh() error {
-	s.conn.UnregisterTable(tableName)
-	s.table.reset()
-
-	if err := s.conn.RegisterTable(tableName, s.table); err != nil {
+	if err := s.refresh(tableName, s.table, s.table.reset); err != nil {
 		return err
 	}
 
-	return nil
+	return s.refresh(snapshotTableName, s.snapshots, s.snapshots.reset)
 }
 
-func (s *store) QueryByEventType(eventType string) (eventstore.Events, error) {
-	var events eventstore.Events
+func (s *store) refresh(name string, table jsondb.Table, reset func()) error {
+	if err := s.conn.Reload(name); err != jsondb.ErrTableRewritten {
+		return err
+	}
 
-	for records := range s.table.Scan() {
-		event := new(eventstore.Event)
-		if err := json.Unmarshal(records, event); err != nil {
-			return nil, err
-		}
+	s.conn.UnregisterTable(name)
+	reset()
 
-		if event.Type != eventType {
-			continue
-		}
+	return s.conn.RegisterTable(name, table)
+}
 
-		events = append(events, *event)
+func (s *store) QueryByEventType(eventType string) (eventstore.Events, error) {
+	events, err := eventstore.Collect(
+		s.table.iterateTypes([]string{eventType}, 0, nil),
+	)
+	if err != nil {
+		return nil, err
 	}
 
 	sort.Sort(events)
@@ -64,20 +85,32 @@ func (s *store) QueryByEventType(eventType string) (eventstore.Events, error) {
 	return events, nil
 }
 
-func (s *store) Query(id string) (eventstore.Events, error) {
-	var events eventstore.Events
+func (s *store) QueryByFilter(filter eventstore.Filter) (eventstore.Events, error) {
+	match := func(event *eventstore.Event) bool {
+		return filter.Match(*event)
+	}
 
-	for records := range s.table.Scan() {
-		event := new(eventstore.Event)
-		if err := json.Unmarshal(records, event); err != nil {
-			return nil, err
-		}
+	if len(filter.EventTypes) > 0 {
+		return eventstore.Collect(s.table.iterateTypes(filter.Types(), 0, match))
+	}
 
-		if event.ID != id {
-			continue
-		}
+	return eventstore.Collect(s.table.iterate(0, match))
+}
+
+// Query returns the stream's events from its latest snapshot on.
+func (s *store) Query(id string) (eventstore.Events, error) {
+	events, err := s.query(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveSnapshot(s.snapshots, id, events), nil
+}
 
-		events = append(events, *event)
+func (s *store) query(id string) (eventstore.Events, error) {
+	events, err := eventstore.Collect(s.table.iterateStream(id, 0, nil))
+	if err != nil {
+		return nil, err
 	}
 
 	sort.Sort(events)
@@ -85,33 +118,114 @@ func (s *store) Query(id string) (eventstore.Events, error) {
 	return events, nil
 }
 
+func (s *store) ReadAll(position int64, limit int) (eventstore.Events, error) {
+	return eventstore.Collect(s.IterateAll(position, limit))
+}
+
+func (s *store) Iterate(id string, version int64, limit int) eventstore.Iterator {
+	return s.table.iterateStream(id, limit, func(event *eventstore.Event) bool {
+		return event.Version >= version
+	})
+}
+
+func (s *store) IterateAll(position int64, limit int) eventstore.Iterator {
+	return s.table.iterateFrom(position, limit)
+}
+
 func (s *store) Put(id string, version int64, event eventstore.Event) error {
-	if event.Version == version {
-		return errors.New("Conflict Error")
-	}
+	return s.Append(id, version, eventstore.Events{event})
+}
 
-	events, err := s.Query(id)
+func (s *store) Append(id string, version int64, events eventstore.Events) error {
+	s.Lock()
+	defer s.Unlock()
+
+	current, err := s.query(id)
 	if err != nil {
 		return err
 	}
 
-	if num := len(events); num > 0 &&
-		(events[num-1].Version != version || event.Version < version) {
-		return fmt.Errorf("Conflict Error")
+	if eventstore.Appended(current, events) {
+		return nil
 	}
 
-	return s.table.Put(event)
-}
+	if num := len(current); num > 0 && current[num-1].Version != version {
+		return &eventstore.ErrConcurrencyConflict{
+			StreamID:        id,
+			ExpectedVersion: version,
+			ActualVersion:   current[num-1].Version,
+		}
+	}
+
+	expected := version
+	for _, event := range events {
+		if event.ID != id || event.Version != expected+1 {
+			return eventstore.ErrEventOutOfSequence
+		}
+		expected = event.Version
+	}
+
+	var previous string
+	if num := len(current); num > 0 {
+		previous = current[num-1].Hash
+	}
+
+	if err = eventstore.Chain(previous, events); err != nil {
+		return err
+	}
+
+	positioned := make(eventstore.Events, len(events))
+	values := make([]interface{}, len(events))
+	position := s.table.lastPosition()
+	for i, event := range events {
+		event.Position = position + int64(i) + 1
+		positioned[i], values[i] = event, event
+	}
+
+	if err = s.conn.Put(tableName, values...); err != nil {
+		return err
+	}
+
+	for i := range positioned {
+		events[i].Position = positioned[i].Position
+	}
 
-func (s *store) Snapshot(event eventstore.Event, snapshot interface{}) error {
 	return nil
 }
 
+func (s *store) Snapshot(event eventstore.Event, state interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	raw := json.RawMessage(data)
+	return s.conn.Put(snapshotTableName, snapshot{
+		ID:       event.ID,
+		Version:  event.Version,
+		Snapshot: &raw,
+	})
+}
+
+// table keeps records in the order they were appended, which is also the
+// order of their positions, and indexes where each stream's and each event
+// type's records are so reads only touch the records they need.
 type table struct {
-	records []json.RawMessage
+	records   []json.RawMessage
+	positions []int64
+	streams   map[string][]int
+	types     map[string][]int
+	position  int64
 	sync.RWMutex
 }
 
+func newTable() *table {
+	table := new(table)
+	table.streams = make(map[string][]int)
+	table.types = make(map[string][]int)
+	return table
+}
+
 func (t *table) Scan() chan json.RawMessage {
 
 	records := make(chan json.RawMessage)
@@ -127,6 +241,74 @@ func (t *table) Scan() chan json.RawMessage {
 	return records
 }
 
+// ScanPrefix returns the events with keys starting with prefix, in the order
+// they were appended. An event's key is its stream ID and version separated by
+// a slash, so a stream ID followed by a slash selects the whole stream.
+func (t *table) ScanPrefix(prefix string) chan json.RawMessage {
+	records := make(chan json.RawMessage)
+	go func() {
+		t.RLock()
+		defer t.RUnlock()
+		defer close(records)
+
+		var indexes []int
+		for id, stream := range t.streams {
+			switch {
+			case strings.HasPrefix(id+"/", prefix):
+				indexes = append(indexes, stream...)
+			case strings.HasPrefix(prefix, id+"/"):
+				for _, i := range stream {
+					event := new(eventstore.Event)
+					if err := json.Unmarshal(t.records[i], event); err != nil {
+						log.Println("Error: Unmarshaling event: ", err)
+						continue
+					}
+
+					if strings.HasPrefix(eventKey(event.ID, event.Version), prefix) {
+						indexes = append(indexes, i)
+					}
+				}
+			}
+		}
+
+		sort.Ints(indexes)
+		for _, i := range indexes {
+			records <- t.records[i]
+		}
+	}()
+
+	return records
+}
+
+// Get returns the event with the key made by eventKey.
+func (t *table) Get(key string) (json.RawMessage, error) {
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return nil, jsondb.ErrRecordNotFound
+	}
+
+	version, err := strconv.ParseInt(key[i+1:], 10, 64)
+	if err != nil {
+		return nil, jsondb.ErrRecordNotFound
+	}
+
+	t.RLock()
+	defer t.RUnlock()
+
+	for _, index := range t.streams[key[:i]] {
+		event := new(eventstore.Event)
+		if err = json.Unmarshal(t.records[index], event); err != nil {
+			return nil, err
+		}
+
+		if event.Version == version {
+			return t.records[index], nil
+		}
+	}
+
+	return nil, jsondb.ErrRecordNotFound
+}
+
 func (t *table) Put(v interface{}) error {
 	t.Lock()
 	defer t.Unlock()
@@ -140,24 +322,86 @@ func (t *table) Put(v interface{}) error {
 		return err
 	}
 
-	t.records = append(t.records, record)
+	return t.add(record)
+}
 
-	return nil
+// Delete always fails, events are never removed.
+func (t *table) Delete(key string) error {
+	return errAppendOnly
+}
+
+// lastPosition of the records in the table
+func (t *table) lastPosition() int64 {
+	t.RLock()
+	defer t.RUnlock()
+	return t.position
 }
 
+// Load positions any records written before events had a position in the order
+// they appear in the file.
 func (t *table) Load(records chan json.RawMessage) error {
 	t.Lock()
 	defer t.Unlock()
 
 	for record := range records {
-		t.records = append(t.records, record)
+		if err := t.add(record); err != nil {
+			drain(records)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// add indexes a marshaled event, giving it the next position when it does not
+// already have a later one. Must be called with the table locked.
+func (t *table) add(record json.RawMessage) error {
+	event := new(eventstore.Event)
+	if err := json.Unmarshal(record, event); err != nil {
+		return err
 	}
 
+	if event.Position <= t.position {
+		event.Position = t.position + 1
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		record = data
+	}
+
+	t.index(event, record)
 	return nil
 }
 
+// index appends a record and adds it to the indexes. Must be called with the
+// table locked.
+func (t *table) index(event *eventstore.Event, record json.RawMessage) {
+	i := len(t.records)
+	t.records = append(t.records, record)
+	t.positions = append(t.positions, event.Position)
+	t.streams[event.ID] = append(t.streams[event.ID], i)
+	t.types[event.Type] = append(t.types[event.Type], i)
+	t.position = event.Position
+}
+
 func (t *table) reset() {
 	t.Lock()
 	defer t.Unlock()
 	t.records = nil
+	t.positions = nil
+	t.streams = make(map[string][]int)
+	t.types = make(map[string][]int)
+	t.position = 0
+}
+
+// eventKey is how the table keys an event.
+func eventKey(id string, version int64) string {
+	return id + "/" + strconv.FormatInt(version, 10)
+}
+
+// drain unblocks the connection's file reader when Load gives up early.
+func drain(records chan json.RawMessage) {
+	for range records {
+	}
 }
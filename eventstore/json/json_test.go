@@ -3,7 +3,10 @@ package json
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -13,19 +16,7 @@ import (
 )
 
 func TestNewStore(t *testing.T) {
-	conn, _ := jsondb.Open(".")
-	conn.SetFileCreator(func(f string) (io.Writer, error) {
-		return bytes.NewBuffer(nil), nil
-	})
-
-	conn.SetFileProvider(func(f string) (io.Reader, error) {
-		return strings.NewReader(testData), nil
-	})
-
-	store, err := New(conn)
-	if err != nil {
-		t.Fatal(err)
-	}
+	store := newTestStore(t, testData)
 
 	events, err := store.Query("id")
 	if err != nil {
@@ -47,8 +38,312 @@ func TestNewStore(t *testing.T) {
 	}); err != nil {
 		t.Fatal(err)
 	}
+}
+
+func TestAppendAllOrNothing(t *testing.T) {
+	store := newTestStore(t, testData)
+
+	if err := store.Append("id", 4, eventstore.Events{
+		eventstore.Event{ID: "id", Version: 5, Type: "NewItem"},
+		eventstore.Event{ID: "id", Version: 7, Type: "NewItem"},
+	}); err == nil {
+		t.Fatal("Expected Error")
+	}
+
+	events, err := store.Query("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected: 4 event(s), Got: %d event(s)", len(events))
+	}
+
+	if err := store.Append("id", 4, eventstore.Events{
+		eventstore.Event{ID: "id", Version: 5, Type: "NewItem"},
+		eventstore.Event{ID: "id", Version: 6, Type: "NewItem"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Append("id", 4, eventstore.Events{
+		eventstore.Event{ID: "id", Version: 5, Type: "NewItem"},
+	})
+	conflict, ok := err.(*eventstore.ErrConcurrencyConflict)
+	if !ok {
+		t.Fatalf("Expected: *eventstore.ErrConcurrencyConflict, Got: %T", err)
+	}
+	if conflict.ExpectedVersion != 4 || conflict.ActualVersion != 6 {
+		t.Fatalf("Expected: 4/6, Got: %d/%d",
+			conflict.ExpectedVersion, conflict.ActualVersion)
+	}
+
+	if events, err = store.Query("id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 6 {
+		t.Fatalf("Expected: 6 event(s), Got: %d event(s)", len(events))
+	}
+}
+
+func TestAppendIsIdempotent(t *testing.T) {
+	store := newTestStore(t, testData)
+
+	events := eventstore.Events{
+		eventstore.Event{ID: "id", EventID: "a", Version: 5, Type: "NewItem"},
+		eventstore.Event{ID: "id", EventID: "b", Version: 6, Type: "NewItem"},
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := store.Append("id", 4, events); err != nil {
+			t.Fatalf("Attempt %d: %v", attempt, err)
+		}
+	}
+
+	events, err := store.Query("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 6 {
+		t.Fatalf("Expected: 6 event(s), Got: %d event(s)", len(events))
+	}
+
+	err = store.Append("id", 4, eventstore.Events{
+		eventstore.Event{ID: "id", EventID: "c", Version: 5, Type: "NewItem"},
+	})
+	if !eventstore.IsConcurrencyConflict(err) {
+		t.Fatalf("Expected: *eventstore.ErrConcurrencyConflict, Got: %v", err)
+	}
+}
+
+func TestReadAll(t *testing.T) {
+	store := newTestStore(t, testData)
+
+	events := eventstore.Events{
+		eventstore.Event{ID: "other", Version: 1, Type: "NewItem"},
+	}
+	if err := store.Append("other", 0, events); err != nil {
+		t.Fatal(err)
+	}
+	if events[0].Position != 5 {
+		t.Fatalf("Expected: position 5, Got: %d", events[0].Position)
+	}
+
+	events, err := store.ReadAll(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Position != 3 || events[1].Position != 4 {
+		t.Fatalf("Expected: positions 3 and 4, Got: %v", events)
+	}
+
+	if events, err = store.ReadAll(4, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ID != "other" {
+		t.Fatalf("Expected: the other stream's event, Got: %v", events)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	store := newTestStore(t, testData)
+
+	it := store.Iterate("id", 2, 2)
+	defer it.Close()
+
+	var versions []int64
+	for it.Next() {
+		versions = append(versions, it.Event().Version)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 2 || versions[0] != 2 || versions[1] != 3 {
+		t.Fatalf("Expected: versions 2 and 3, Got: %v", versions)
+	}
+}
+
+func TestQueryByFilter(t *testing.T) {
+	store := newTestStore(t, testData)
+
+	if err := store.Append("other", 0, eventstore.Events{
+		eventstore.Event{ID: "other", Version: 1, Type: "OldItem", Timestamp: 1486332400},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter   eventstore.Filter
+		expected []int64
+	}{
+		{eventstore.Filter{}, []int64{1, 2, 3, 4, 5}},
+		{eventstore.Filter{EventTypes: []string{"OldItem", "NewItem"}}, []int64{1, 2, 3, 4, 5}},
+		{eventstore.Filter{EventTypes: []string{"OldItem"}}, []int64{5}},
+		{eventstore.Filter{EventTypes: []string{"OldItem", "OldItem"}}, []int64{5}},
+		{eventstore.Filter{From: 1486332324, To: 1486332400}, []int64{2, 3, 5}},
+		{eventstore.Filter{StreamPrefix: "oth"}, []int64{5}},
+	}
+
+	for i, test := range tests {
+		events, err := store.QueryByFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var positions []int64
+		for _, event := range events {
+			positions = append(positions, event.Position)
+		}
+
+		if fmt.Sprint(positions) != fmt.Sprint(test.expected) {
+			t.Fatalf("Test %d, Expected: %v, Got: %v", i, test.expected, positions)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eventstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conn, err := jsondb.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetAppendOnly(true)
+
+	writer, err := New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readOnly, err := jsondb.OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := New(readOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for version := int64(1); version <= 2; version++ {
+		if err = writer.Put("id", version-1, eventstore.Event{
+			ID:      "id",
+			Version: version,
+			Type:    "NewItem",
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = reader.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+
+		events, err := reader.Query("id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(events)) != version {
+			t.Fatalf("Expected: %d event(s), Got: %d event(s)", version, len(events))
+		}
+	}
+
+	// a compacted file is read again from the start
+	if err = conn.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err = reader.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := reader.ReadAll(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Position != 2 {
+		t.Fatalf("Expected: 2 event(s) ending at position 2, Got: %v", events)
+	}
+}
+
+func TestTableKeys(t *testing.T) {
+	conn, _ := jsondb.Open(".")
+	conn.SetFileProvider(func(f string) (io.Reader, error) {
+		if f != "events.json" {
+			return nil, &os.PathError{Op: "open", Path: f, Err: os.ErrNotExist}
+		}
+		return strings.NewReader(testData + strings.Replace(testData, `"id":"id"`, `"id":"id2"`, -1)), nil
+	})
+
+	table := newTable()
+	if err := conn.RegisterTable(tableName, table); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := table.Get(eventKey("id2", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := new(eventstore.Event)
+	json.Unmarshal(record, event)
+	if event.ID != "id2" || event.Version != 3 {
+		t.Fatalf("Expected: id2/3, Got: %s/%d", event.ID, event.Version)
+	}
+
+	if _, err = table.Get(eventKey("id", 5)); err != jsondb.ErrRecordNotFound {
+		t.Fatalf("Expected: %v, Got: %v", jsondb.ErrRecordNotFound, err)
+	}
+
+	for prefix, expected := range map[string]int{
+		"":      8,
+		"id":    8,
+		"id/":   4,
+		"id2/4": 1,
+		"id/1":  1,
+		"x":     0,
+	} {
+		var count int
+		for range table.ScanPrefix(prefix) {
+			count++
+		}
+		if count != expected {
+			t.Fatalf("Prefix %q Expected: %d record(s), Got: %d record(s)",
+				prefix, expected, count)
+		}
+	}
+
+	if err = conn.Delete(tableName, eventKey("id", 1)); err != errAppendOnly {
+		t.Fatalf("Expected: %v, Got: %v", errAppendOnly, err)
+	}
+}
+
+// newTestStore opens an event store holding the events in data, one json
+// record per line. Nothing it writes reaches the disk, and it is closed when
+// the test finishes.
+func newTestStore(t *testing.T, data string) eventstore.EventStore {
+	t.Helper()
+
+	conn, err := jsondb.Open(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetFileCreator(func(f string) (io.Writer, error) {
+		return bytes.NewBuffer(nil), nil
+	})
+
+	conn.SetFileProvider(func(f string) (io.Reader, error) {
+		return strings.NewReader(data), nil
+	})
+
+	store, err := New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	conn.Close()
+	return store
 }
 
 const testData = `{"id":"id","version":1,"type":"NewItem","timestamp":1486332029,"data":{"foo":"bar"}}
@@ -0,0 +1,41 @@
+// Package jsontest provides a json event store for tests in other packages.
+package jsontest
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	jsondb "github.com/ebittleman/voting/database/json"
+	"github.com/ebittleman/voting/eventstore"
+	"github.com/ebittleman/voting/eventstore/json"
+)
+
+// NewStore opens an event store holding the events in data, one json record
+// per line. Nothing it writes reaches the disk, and it is closed when the test
+// finishes.
+func NewStore(t testing.TB, data string) eventstore.EventStore {
+	t.Helper()
+
+	conn, err := jsondb.Open(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetFileCreator(func(f string) (io.Writer, error) {
+		return bytes.NewBuffer(nil), nil
+	})
+
+	conn.SetFileProvider(func(f string) (io.Reader, error) {
+		return strings.NewReader(data), nil
+	})
+
+	store, err := json.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
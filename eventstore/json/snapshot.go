@@ -0,0 +1,166 @@
+package json
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+
+	jsondb "github.com/ebittleman/voting/database/json"
+	"github.com/ebittleman/voting/eventstore"
+)
+
+const snapshotTableName = "snapshots"
+
+// snapshot state of a stream as of the event at Version
+type snapshot struct {
+	ID       string           `json:"id"`
+	Version  int64            `json:"version"`
+	Snapshot *json.RawMessage `json:"snapshot"`
+}
+
+// snapshotTable keeps only the latest snapshot of each stream
+type snapshotTable struct {
+	records map[string]snapshot
+	sync.RWMutex
+}
+
+func newSnapshotTable() *snapshotTable {
+	table := new(snapshotTable)
+	table.records = make(map[string]snapshot)
+	return table
+}
+
+func (t *snapshotTable) get(id string) (snapshot, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	record, ok := t.records[id]
+	return record, ok
+}
+
+func (t *snapshotTable) Scan() chan json.RawMessage {
+	return t.ScanPrefix("")
+}
+
+// ScanPrefix returns the snapshots of streams with IDs starting with prefix.
+func (t *snapshotTable) ScanPrefix(prefix string) chan json.RawMessage {
+	records := make(chan json.RawMessage)
+	go func() {
+		t.RLock()
+		defer t.RUnlock()
+		defer close(records)
+		var (
+			record []byte
+			err    error
+		)
+		for id, snapshot := range t.records {
+			if !strings.HasPrefix(id, prefix) {
+				continue
+			}
+
+			if record, err = json.Marshal(&snapshot); err != nil {
+				log.Println("Error: Marshaling snapshot: ", err)
+				return
+			}
+			records <- record
+		}
+	}()
+
+	return records
+}
+
+// Get returns the latest snapshot of the stream with ID id.
+func (t *snapshotTable) Get(id string) (json.RawMessage, error) {
+	record, ok := t.get(id)
+	if !ok {
+		return nil, jsondb.ErrRecordNotFound
+	}
+
+	return json.Marshal(&record)
+}
+
+func (t *snapshotTable) Put(v interface{}) error {
+	t.Lock()
+	defer t.Unlock()
+
+	record, ok := v.(snapshot)
+	if !ok {
+		return errExpectedSnapshot
+	}
+
+	if existing, ok := t.records[record.ID]; ok &&
+		existing.Version > record.Version {
+		return nil
+	}
+
+	t.records[record.ID] = record
+
+	return nil
+}
+
+// Delete drops a stream's snapshot, so it is loaded from its first event.
+func (t *snapshotTable) Delete(id string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.records, id)
+	return nil
+}
+
+func (t *snapshotTable) Load(records chan json.RawMessage) error {
+	t.Lock()
+	defer t.Unlock()
+
+	for record := range records {
+		row := new(snapshot)
+		if err := json.Unmarshal(record, row); err != nil {
+			drain(records)
+			return err
+		}
+
+		if row.Snapshot == nil {
+			continue
+		}
+
+		if existing, ok := t.records[row.ID]; ok &&
+			existing.Version > row.Version {
+			continue
+		}
+
+		t.records[row.ID] = *row
+	}
+
+	return nil
+}
+
+func (t *snapshotTable) reset() {
+	t.Lock()
+	defer t.Unlock()
+	t.records = make(map[string]snapshot)
+}
+
+// resolveSnapshot drops every event before the stream's latest snapshot and
+// attaches the snapshot to the event it was taken at, the same shape the
+// couchdb store returns.
+func resolveSnapshot(
+	table *snapshotTable,
+	id string,
+	events eventstore.Events,
+) eventstore.Events {
+	record, ok := table.get(id)
+	if !ok {
+		return events
+	}
+
+	for i, event := range events {
+		if event.Version != record.Version {
+			continue
+		}
+
+		events = events[i:]
+		events[0].Snapshot = record.Snapshot
+		return events
+	}
+
+	return events
+}
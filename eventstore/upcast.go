@@ -0,0 +1,88 @@
+package eventstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Upcaster converts the payload of an event from one schema version to the
+// next.
+type Upcaster func(data *json.RawMessage) (*json.RawMessage, error)
+
+// Upcasters registry of the upcasters for each event type. Events recorded
+// before schema versions existed are treated as version 1.
+type Upcasters struct {
+	upcasters map[string]map[int]Upcaster
+	sync.RWMutex
+}
+
+// NewUpcasters creates an empty registry, where every event type is at
+// version 1.
+func NewUpcasters() *Upcasters {
+	u := new(Upcasters)
+	u.upcasters = make(map[string]map[int]Upcaster)
+	return u
+}
+
+// Register adds an upcaster that converts eventType payloads from version to
+// version + 1. Registering upcasters for consecutive versions builds a chain.
+func (u *Upcasters) Register(eventType string, version int, upcaster Upcaster) {
+	u.Lock()
+	defer u.Unlock()
+
+	if _, ok := u.upcasters[eventType]; !ok {
+		u.upcasters[eventType] = make(map[int]Upcaster)
+	}
+	u.upcasters[eventType][version] = upcaster
+}
+
+// Version returns the current schema version of an event type, the version
+// new events of that type should be written with.
+func (u *Upcasters) Version(eventType string) int {
+	u.RLock()
+	defer u.RUnlock()
+
+	version := 1
+	for {
+		if _, ok := u.upcasters[eventType][version]; !ok {
+			return version
+		}
+		version++
+	}
+}
+
+// Upcast runs an event through every upcaster between its schema version and
+// the current one.
+func (u *Upcasters) Upcast(event Event) (Event, error) {
+	u.RLock()
+	defer u.RUnlock()
+
+	version := event.SchemaVersion
+	if version < 1 {
+		version = 1
+	}
+
+	for {
+		upcaster, ok := u.upcasters[event.Type][version]
+		if !ok {
+			break
+		}
+
+		data, err := upcaster(event.Data)
+		if err != nil {
+			return event, fmt.Errorf(
+				"Upcasting %s from version %d: %v",
+				event.Type,
+				version,
+				err,
+			)
+		}
+
+		event.Data = data
+		version++
+	}
+
+	event.SchemaVersion = version
+	return event, nil
+}
@@ -47,3 +47,15 @@ func (s *store) Put(row views.ViewRow) error {
 	_, err := s.db.Put(row.ID, row, rev)
 	return err
 }
+
+func (s *store) Delete(id string) error {
+	rev, err := s.db.Rev(id)
+	if couchdb.NotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, err = s.db.Delete(id, rev)
+	return err
+}
@@ -3,13 +3,17 @@ package json
 import (
 	"encoding/json"
 	"log"
+	"strings"
 	"sync"
 
 	jsondb "github.com/ebittleman/voting/database/json"
 	"github.com/ebittleman/voting/views"
 )
 
+const tableName = "views"
+
 type store struct {
+	conn  *jsondb.Connection
 	table *table
 }
 
@@ -17,27 +21,35 @@ type store struct {
 func NewStore(conn *jsondb.Connection) (views.ViewStore, error) {
 	table := new(table)
 	table.records = make(map[string]views.ViewRow)
-	if err := conn.RegisterTable("views", table); err != nil {
+	if err := conn.RegisterTable(tableName, table); err != nil {
 		return nil, err
 	}
 
 	store := new(store)
+	store.conn = conn
 	store.table = table
 
 	return store, nil
 }
 
 func (s *store) Get(id string) (row views.ViewRow, err error) {
-	row, ok := s.table.records[id]
-	if !ok {
+	record, err := s.table.Get(id)
+	if err == jsondb.ErrRecordNotFound {
 		return row, views.ErrRowNotFound
+	} else if err != nil {
+		return row, err
 	}
 
+	err = json.Unmarshal(record, &row)
 	return
 }
 
 func (s *store) Put(row views.ViewRow) error {
-	return s.table.Put(row)
+	return s.conn.Put(tableName, row)
+}
+
+func (s *store) Delete(id string) error {
+	return s.conn.Delete(tableName, id)
 }
 
 type table struct {
@@ -46,6 +58,11 @@ type table struct {
 }
 
 func (t *table) Scan() chan json.RawMessage {
+	return t.ScanPrefix("")
+}
+
+// ScanPrefix returns the rows with IDs starting with prefix.
+func (t *table) ScanPrefix(prefix string) chan json.RawMessage {
 	records := make(chan json.RawMessage)
 	go func() {
 		t.RLock()
@@ -55,7 +72,11 @@ func (t *table) Scan() chan json.RawMessage {
 			record []byte
 			err    error
 		)
-		for _, viewRow := range t.records {
+		for id, viewRow := range t.records {
+			if !strings.HasPrefix(id, prefix) {
+				continue
+			}
+
 			if record, err = json.Marshal(&viewRow); err != nil {
 				log.Println("Error: Marshaling views.ViewRow: ", err)
 				return
@@ -67,6 +88,18 @@ func (t *table) Scan() chan json.RawMessage {
 	return records
 }
 
+func (t *table) Get(id string) (json.RawMessage, error) {
+	t.RLock()
+	defer t.RUnlock()
+
+	row, ok := t.records[id]
+	if !ok {
+		return nil, jsondb.ErrRecordNotFound
+	}
+
+	return json.Marshal(&row)
+}
+
 func (t *table) Put(v interface{}) error {
 	t.Lock()
 	defer t.Unlock()
@@ -85,6 +118,14 @@ func (t *table) Put(v interface{}) error {
 	return nil
 }
 
+func (t *table) Delete(id string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.records, id)
+	return nil
+}
+
 func (t *table) Load(records chan json.RawMessage) error {
 	t.Lock()
 	defer t.Unlock()
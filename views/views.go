@@ -22,4 +22,6 @@ type ViewRow struct {
 type ViewStore interface {
 	Get(id string) (ViewRow, error)
 	Put(row ViewRow) error
+	// Delete removes a row, if there is one.
+	Delete(id string) error
 }
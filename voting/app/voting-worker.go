@@ -4,23 +4,34 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
+	"time"
 
 	"github.com/ebittleman/voting/bus"
 	"github.com/ebittleman/voting/bus/ironmq"
+	jsondb "github.com/ebittleman/voting/database/json"
 	"github.com/ebittleman/voting/dispatcher"
 	"github.com/ebittleman/voting/dispatcher/filters"
 	"github.com/ebittleman/voting/eventmanager"
 	"github.com/ebittleman/voting/eventstore"
 	couchdbEventStore "github.com/ebittleman/voting/eventstore/couchdb"
+	jsonEventStore "github.com/ebittleman/voting/eventstore/json"
 	"github.com/ebittleman/voting/views"
 	couchdbViews "github.com/ebittleman/voting/views/couchdb"
+	jsonViews "github.com/ebittleman/voting/views/json"
 	"github.com/ebittleman/voting/voting"
 	"github.com/ebittleman/voting/voting/subscribers"
 	votingViews "github.com/ebittleman/voting/voting/views"
 	couchdb "github.com/fjl/go-couchdb"
 )
 
-// VotingWorkerConfig of a voting-working application
+// viewsFlushInterval how often views written by the worker are flushed to
+// disk when using the json database.
+const viewsFlushInterval = 2 * time.Second
+
+// VotingWorkerConfig of a voting-working application. When JSONDir is set the
+// worker reads events from the json database there instead of couchdb, and
+// keeps its views in the views directory inside it.
 type VotingWorkerConfig struct {
 	IronQueueName string
 	JSONDir       string
@@ -30,8 +41,9 @@ type votingWorker struct {
 	jsonDir       string
 	ironQueueName string
 
-	client *couchdb.Client
-	// conn             *jsondb.Connection
+	client           *couchdb.Client
+	eventsConn       *jsondb.Connection
+	viewsConn        *jsondb.Connection
 	dispatcher       dispatcher.Runnable
 	eventManager     eventmanager.EventManager
 	eventStore       eventstore.EventStore
@@ -54,11 +66,19 @@ func NewVotingWorker(config VotingWorkerConfig) Application {
 	return c
 }
 
+// Close shuts down every component, and then the json databases they were
+// using, flushing what is left of the views.
 func (c *votingWorker) Close() error {
 	for _, closer := range c.closers {
 		closer.Close()
 	}
 
+	for _, conn := range []*jsondb.Connection{c.viewsConn, c.eventsConn} {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
 	return nil
 }
 
@@ -141,44 +161,46 @@ func (c *votingWorker) Client() (*couchdb.Client, error) {
 	return c.client, nil
 }
 
-// func (c *votingWorker) Connection() (*jsondb.Connection, error) {
-// 	if c.conn != nil {
-// 		return c.conn, nil
-// 	}
-//
-// 	conn, err := jsondb.Open(c.jsonDir)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	// setEventsReadOnly dirty little trick to skip writing the events file.
-// 	fileCreator := conn.GetFileCreator()
-// 	conn.SetFileCreator(func(f string) (io.Writer, error) {
-// 		if !strings.Contains(f, "events") {
-// 			return fileCreator(f)
-// 		}
-//
-// 		return &bytes.Buffer{}, nil
-// 	})
-//
-// 	c.closers = append(c.closers, conn)
-// 	c.conn = conn
-//
-// 	// write the views file to disk every couple of seconds
-// 	go func() {
-// 		for {
-// 			select {
-// 			case <-time.After(2 * time.Second):
-// 				if flushErr := conn.Flush(); flushErr != nil {
-// 					log.Println("Error: Couldn't write db to disk: ", flushErr)
-// 					return
-// 				}
-// 			}
-// 			log.Println("Info: wrote db to disk.")
-// 		}
-// 	}()
-//
-// 	return c.conn, nil
-// }
+// EventsConnection opens the json database holding the events read only.
+// Events are written by another process, which holds the directory's lock, so
+// the worker only ever reloads what it has written.
+func (c *votingWorker) EventsConnection() (*jsondb.Connection, error) {
+	if c.eventsConn != nil {
+		return c.eventsConn, nil
+	}
+
+	conn, err := jsondb.OpenReadOnly(c.jsonDir)
+	if err != nil {
+		return nil, err
+	}
+	c.eventsConn = conn
+
+	return c.eventsConn, nil
+}
+
+// ViewsConnection opens the json database the worker writes its views to, in
+// a directory of its own so that it doesn't need the events directory's lock.
+// Only one worker can have it open, any other gets jsondb.ErrLocked. Changed
+// views are written to disk every couple of seconds.
+func (c *votingWorker) ViewsConnection() (*jsondb.Connection, error) {
+	if c.viewsConn != nil {
+		return c.viewsConn, nil
+	}
+
+	dir := path.Join(c.jsonDir, "views")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	conn, err := jsondb.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetFlushInterval(viewsFlushInterval)
+	c.viewsConn = conn
+
+	return c.viewsConn, nil
+}
 
 func (c *votingWorker) EventManager() eventmanager.EventManager {
 	if c.eventManager != nil {
@@ -196,14 +218,25 @@ func (c *votingWorker) EventStore() (eventstore.EventStore, error) {
 		return c.eventStore, nil
 	}
 
-	// conn, err := c.Connection()
-	client, err := c.Client()
-	if err != nil {
-		return nil, err
+	var (
+		store eventstore.EventStore
+		err   error
+	)
+	if c.jsonDir != "" {
+		var conn *jsondb.Connection
+		if conn, err = c.EventsConnection(); err != nil {
+			return nil, err
+		}
+
+		store, err = jsonEventStore.New(conn)
+	} else {
+		var client *couchdb.Client
+		if client, err = c.Client(); err != nil {
+			return nil, err
+		}
+
+		store, err = couchdbEventStore.New(client)
 	}
-
-	// store, err := json.New(conn)
-	store, err := couchdbEventStore.New(client)
 	if err != nil {
 		return nil, err
 	}
@@ -271,14 +304,25 @@ func (c *votingWorker) ViewStore() (views.ViewStore, error) {
 		return c.viewStore, nil
 	}
 
-	// conn, err := c.Connection()
-	client, err := c.Client()
-	if err != nil {
-		return nil, err
+	var (
+		viewStore views.ViewStore
+		err       error
+	)
+	if c.jsonDir != "" {
+		var conn *jsondb.Connection
+		if conn, err = c.ViewsConnection(); err != nil {
+			return nil, err
+		}
+
+		viewStore, err = jsonViews.NewStore(conn)
+	} else {
+		var client *couchdb.Client
+		if client, err = c.Client(); err != nil {
+			return nil, err
+		}
+
+		viewStore, err = couchdbViews.NewStore(client)
 	}
-
-	// viewStore, err := jsonViews.NewStore(conn)
-	viewStore, err := couchdbViews.NewStore(client)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,11 @@
 package commands
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/ebittleman/voting/eventstore"
+	uuid "github.com/satori/go.uuid"
+)
 
 var (
 	// ErrNotImplemented place holder error until a command is implemented
@@ -11,3 +16,49 @@ var (
 type Command interface {
 	Run() error
 }
+
+// WithMetadata returns a copy of a command that stamps metadata on every event
+// it emits.
+func WithMetadata(command Command, metadata eventstore.Metadata) Command {
+	switch c := command.(type) {
+	case CreatePoll:
+		c.Metadata = metadata
+		return c
+	case OpenPoll:
+		c.Metadata = metadata
+		return c
+	case ClosePoll:
+		c.Metadata = metadata
+		return c
+	case CastBallot:
+		c.Metadata = metadata
+		return c
+	case Retry:
+		c.Command = WithMetadata(c.Command, metadata)
+		return c
+	}
+
+	return command
+}
+
+// newMetadata fills in what the caller left out. A command without a
+// correlation id starts a new request. The causation id identifies the command
+// itself and the ids of the events it emits are derived from it, so callers
+// that may deliver the same command twice should set it to something stable;
+// otherwise every run is treated as a new command.
+func newMetadata(metadata eventstore.Metadata) eventstore.Metadata {
+	metadata = metadata.Copy()
+	if metadata == nil {
+		metadata = make(eventstore.Metadata)
+	}
+
+	if metadata.CorrelationID() == "" {
+		metadata[eventstore.CorrelationIDKey] = uuid.NewV4().String()
+	}
+
+	if metadata.CausationID() == "" {
+		metadata[eventstore.CausationIDKey] = uuid.NewV4().String()
+	}
+
+	return metadata
+}
@@ -2,7 +2,6 @@ package commands
 
 import (
 	"errors"
-	"log"
 
 	"github.com/ebittleman/voting/eventmanager"
 	"github.com/ebittleman/voting/eventstore"
@@ -23,6 +22,7 @@ type CreatePoll struct {
 	eventManager eventmanager.EventManager
 	ID           string
 	Issues       []model.Issue
+	Metadata     eventstore.Metadata
 }
 
 // NewCreatePoll initializes a new CreatePoll command for execution.
@@ -49,7 +49,11 @@ func (c CreatePoll) Run() error {
 		return ErrPollAlreadyExists
 	}
 
-	poll := model.LoadPoll(c.ID, nil)
+	poll, err := model.LoadPoll(c.ID, nil)
+	if err != nil {
+		return err
+	}
+	poll.SetMetadata(newMetadata(c.Metadata))
 	for _, issue := range c.Issues {
 		poll.AppendIssue(issue)
 	}
@@ -71,6 +75,7 @@ type OpenPoll struct {
 	eventStore   eventstore.EventStore
 	eventManager eventmanager.EventManager
 	ID           string
+	Metadata     eventstore.Metadata
 }
 
 // NewOpenPoll initializes a new OpenPoll command for execution
@@ -98,7 +103,11 @@ func (o OpenPoll) Run() error {
 		return ErrPollNotFound
 	}
 
-	poll := model.LoadPoll(o.ID, events)
+	poll, err := model.LoadPoll(o.ID, events)
+	if err != nil {
+		return err
+	}
+	poll.SetMetadata(newMetadata(o.Metadata))
 	if err = poll.OpenPolls(); err != nil {
 		return err
 	}
@@ -133,6 +142,7 @@ type ClosePoll struct {
 	eventStore   eventstore.EventStore
 	eventManager eventmanager.EventManager
 	ID           string
+	Metadata     eventstore.Metadata
 }
 
 // Run executes ClosePoll command
@@ -147,7 +157,11 @@ func (c ClosePoll) Run() error {
 		return ErrPollNotFound
 	}
 
-	poll := model.LoadPoll(c.ID, events)
+	poll, err := model.LoadPoll(c.ID, events)
+	if err != nil {
+		return err
+	}
+	poll.SetMetadata(newMetadata(c.Metadata))
 	if err = poll.ClosePolls(); err != nil {
 		return err
 	}
@@ -161,23 +175,62 @@ func (c ClosePoll) Run() error {
 		c.eventManager.Publish(event)
 	}
 
-	event := events[len(events)-1]
-	snapshot, err := poll.Snapshot()
+	return nil
+}
+
+// NewCastBallot initializes a new CastBallot command for execution
+func NewCastBallot(
+	eventStore eventstore.EventStore,
+	eventManager eventmanager.EventManager,
+	id string,
+	ballot model.Ballot,
+) Command {
+	return CastBallot{
+		eventStore:   eventStore,
+		eventManager: eventManager,
+		ID:           id,
+		Ballot:       ballot,
+	}
+}
+
+// CastBallot adds a vote to the poll
+type CastBallot struct {
+	eventStore   eventstore.EventStore
+	eventManager eventmanager.EventManager
+	ID           string
+	Ballot       model.Ballot
+	Metadata     eventstore.Metadata
+}
+
+// Run executes CastBallot command
+func (c CastBallot) Run() error {
+	var (
+		events eventstore.Events
+		err    error
+	)
+	if events, err = c.eventStore.Query(c.ID); err != nil {
+		return err
+	} else if len(events) < 1 {
+		return ErrPollNotFound
+	}
+
+	poll, err := model.LoadPoll(c.ID, events)
 	if err != nil {
 		return err
 	}
-	if err = c.eventStore.Snapshot(event, snapshot); err != nil {
+	poll.SetMetadata(newMetadata(c.Metadata))
+	if err = poll.CastBallot(c.Ballot); err != nil {
 		return err
 	}
-	log.Println("Wrote Snapshot: ", event.ID, event.Version)
 
-	return nil
-}
+	events = poll.Flush()
+	if err = poll.Commit(c.eventStore, events); err != nil {
+		return err
+	}
 
-// CastBallot adds a vote to the poll
-type CastBallot struct{}
+	for _, event := range events {
+		c.eventManager.Publish(event)
+	}
 
-// Run executes CastBallot command
-func (c CastBallot) Run() error {
-	return ErrNotImplemented
+	return nil
 }
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"log"
+
+	"github.com/ebittleman/voting/eventstore"
+)
+
+// DefaultAttempts number of times a Retry runs its command when no number of
+// attempts is given.
+const DefaultAttempts = 3
+
+// Retry runs a command again whenever it loses a race with another writer to
+// the same stream. Every command reloads its poll from the event store when it
+// runs, so each attempt re-applies the command on top of the latest events.
+type Retry struct {
+	Command  Command
+	Attempts int
+}
+
+// NewRetry wraps a command so it is retried on concurrency conflicts.
+func NewRetry(command Command, attempts int) Command {
+	if attempts < 1 {
+		attempts = DefaultAttempts
+	}
+
+	return Retry{
+		Command:  command,
+		Attempts: attempts,
+	}
+}
+
+// Run executes the wrapped command until it succeeds, fails with something
+// other than a concurrency conflict or runs out of attempts.
+func (r Retry) Run() (err error) {
+	for attempt := 1; attempt <= r.Attempts; attempt++ {
+		if err = r.Command.Run(); !eventstore.IsConcurrencyConflict(err) {
+			return err
+		}
+
+		log.Println("Warn: Attempt ", attempt, " of ", r.Attempts, ": ", err)
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ebittleman/voting/eventmanager"
+	"github.com/ebittleman/voting/eventstore"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		conflicts int
+		succeeds  bool
+	}{
+		{0, true},
+		{DefaultAttempts - 1, true},
+		{DefaultAttempts, false},
+	}
+
+	for _, test := range tests {
+		store := &conflictingStore{conflicts: test.conflicts}
+		em := eventmanager.New()
+
+		err := NewRetry(NewOpenPoll(store, em, "poll"), 0).Run()
+		em.Close()
+
+		if test.succeeds && err != nil {
+			t.Fatalf("%d conflict(s): %v", test.conflicts, err)
+		}
+		if !test.succeeds && !eventstore.IsConcurrencyConflict(err) {
+			t.Fatalf("%d conflict(s): Expected a conflict, Got: %v", test.conflicts, err)
+		}
+
+		attempts := test.conflicts + 1
+		if attempts > DefaultAttempts {
+			attempts = DefaultAttempts
+		}
+		if store.appends != attempts {
+			t.Fatalf(
+				"%d conflict(s): Expected: %d attempt(s), Got: %d attempt(s)",
+				test.conflicts,
+				attempts,
+				store.appends,
+			)
+		}
+	}
+}
+
+func TestRetryStopsOnOtherErrors(t *testing.T) {
+	store := &conflictingStore{}
+	em := eventmanager.New()
+	defer em.Close()
+
+	err := NewRetry(NewOpenPoll(store, em, "missing"), 0).Run()
+	if err != ErrPollNotFound {
+		t.Fatalf("Expected: %v, Got: %v", ErrPollNotFound, err)
+	}
+	if store.queries != 1 {
+		t.Fatalf("Expected: 1 attempt, Got: %d attempt(s)", store.queries)
+	}
+}
+
+// conflictingStore holds one created poll and fails the first conflicts
+// appends to it with a wrapped concurrency conflict, as a store that lost a
+// race to another writer would.
+type conflictingStore struct {
+	conflicts int
+	appends   int
+	queries   int
+	eventstore.EventStore
+}
+
+func (c *conflictingStore) Query(id string) (eventstore.Events, error) {
+	c.queries++
+	if id != "poll" {
+		return nil, nil
+	}
+
+	data := json.RawMessage(`{"id":"poll"}`)
+	return eventstore.Events{
+		eventstore.Event{ID: id, Version: 1, Type: "PollCreated", Data: &data},
+	}, nil
+}
+
+func (c *conflictingStore) Append(
+	id string,
+	version int64,
+	events eventstore.Events,
+) error {
+	if c.appends++; c.appends <= c.conflicts {
+		return fmt.Errorf("Appending: %w", &eventstore.ErrConcurrencyConflict{
+			StreamID:        id,
+			ExpectedVersion: version,
+			ActualVersion:   version + 1,
+		})
+	}
+
+	return nil
+}
+
+func (c *conflictingStore) Snapshot(event eventstore.Event, state interface{}) error {
+	return nil
+}
@@ -1,5 +1,11 @@
 package voting
 
+import "github.com/ebittleman/voting/eventstore"
+
+// Upcasters converts stored event payloads to the shape of the structs in this
+// package. Register an upcaster here whenever one of them changes.
+var Upcasters = eventstore.NewUpcasters()
+
 var EventTypes = []string{
 	"PollCreated",
 	"PollOpened",
@@ -1,18 +1,122 @@
 package model
 
 import (
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/ebittleman/voting/eventstore"
+	uuid "github.com/satori/go.uuid"
 )
 
+// DefaultSnapshotPolicy used by aggregates that have not been given their own.
+// Closing a poll is always snapshotted, as a closed poll is loaded to be counted
+// but never written to again.
+var DefaultSnapshotPolicy = AnyOf(EveryNEvents(100), OnEventTypes("PollClosed"))
+
+// SnapshotPolicy decides, after events are committed, whether an aggregate
+// should be snapshotted. replayed is the number of events, including the
+// committed ones, that would be replayed to load the aggregate again.
+type SnapshotPolicy func(replayed int64, events eventstore.Events) bool
+
+// NoSnapshots never snapshots.
+func NoSnapshots(replayed int64, events eventstore.Events) bool {
+	return false
+}
+
+// EveryNEvents snapshots each time an aggregate's version passes a multiple of
+// n.
+func EveryNEvents(n int64) SnapshotPolicy {
+	return func(replayed int64, events eventstore.Events) bool {
+		if n < 1 || len(events) < 1 {
+			return false
+		}
+
+		first, last := events[0].Version-1, events[len(events)-1].Version
+		return first/n != last/n
+	}
+}
+
+// ReplayThreshold snapshots once loading an aggregate would replay at least max
+// events.
+func ReplayThreshold(max int64) SnapshotPolicy {
+	return func(replayed int64, events eventstore.Events) bool {
+		return max > 0 && replayed >= max
+	}
+}
+
+// OnEventTypes snapshots whenever an event of one of eventTypes is committed.
+func OnEventTypes(eventTypes ...string) SnapshotPolicy {
+	return func(replayed int64, events eventstore.Events) bool {
+		for _, event := range events {
+			for _, eventType := range eventTypes {
+				if event.Type == eventType {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+// AnyOf snapshots whenever one of policies would.
+func AnyOf(policies ...SnapshotPolicy) SnapshotPolicy {
+	return func(replayed int64, events eventstore.Events) bool {
+		for _, policy := range policies {
+			if policy(replayed, events) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Snapshotter aggregates that can capture their current state.
+type Snapshotter interface {
+	Snapshot() (interface{}, error)
+}
+
 // AggregateRoot entity event streams are partitioned on
 type AggregateRoot struct {
 	ID      string
 	Version int64
 
-	events eventstore.Events
+	events   eventstore.Events
+	replayed int64
+	policy   SnapshotPolicy
+	metadata eventstore.Metadata
+}
+
+// SetMetadata sets the metadata stamped on every event that has not been
+// flushed yet, including ones already emitted.
+func (a *AggregateRoot) SetMetadata(metadata eventstore.Metadata) {
+	a.metadata = metadata
+	for i := range a.events {
+		a.events[i].EventID = a.eventID(i, a.events[i].Type)
+		a.events[i].Metadata = metadata.Copy()
+	}
+}
+
+// eventID derives the ids of events from the causation id when there is one,
+// so running the same command again emits events with the same ids and the
+// event store can recognise them as already appended.
+func (a *AggregateRoot) eventID(index int, eventType string) string {
+	causationID := a.metadata.CausationID()
+	if causationID == "" {
+		return uuid.NewV4().String()
+	}
+
+	return uuid.NewV5(
+		uuid.NamespaceOID,
+		fmt.Sprintf("%s/%s/%d/%s", causationID, a.ID, index, eventType),
+	).String()
+}
+
+// SetSnapshotPolicy overrides DefaultSnapshotPolicy for this aggregate.
+func (a *AggregateRoot) SetSnapshotPolicy(policy SnapshotPolicy) {
+	a.policy = policy
 }
 
 // Emit adds an event to the current transaction
@@ -25,8 +129,10 @@ func (a *AggregateRoot) Emit(event eventstore.Event) {
 	}
 
 	event.ID = a.ID
+	event.EventID = a.eventID(len(a.events), event.Type)
 	event.Version = version
 	event.Timestamp = time.Now().UTC().Unix()
+	event.Metadata = a.metadata.Copy()
 
 	a.events = append(a.events, event)
 }
@@ -38,20 +144,57 @@ func (a *AggregateRoot) Flush() eventstore.Events {
 	return events
 }
 
-// Commit writes open events to an event store. TODO: look at inverting this
-// relationship.
+// Commit writes open events to an event store. Either all of the events are
+// written or none of them are. TODO: look at inverting this relationship.
 func (a *AggregateRoot) Commit(store eventstore.EventStore, events eventstore.Events) error {
-	version := a.Version
-	for _, event := range events {
-		if err := store.Put(a.ID, version, event); err != nil {
-			log.Println("Current Version: ", version, " Event Version: ", event.Version)
-			return err
-		}
+	if len(events) < 1 {
+		return nil
+	}
+
+	if err := store.Append(a.ID, a.Version, events); err != nil {
+		log.Println("Current Version: ", a.Version, " Event Version: ", events[0].Version)
+		return err
+	}
+
+	a.Version = events[len(events)-1].Version
+	a.replayed += int64(len(events))
+
+	return nil
+}
+
+// commit writes open events to an event store and then snapshots the aggregate
+// if its snapshot policy asks for one. The events are already committed when
+// the snapshot is taken, so failing to take one is only logged.
+func (a *AggregateRoot) commit(
+	store eventstore.EventStore,
+	events eventstore.Events,
+	snapshotter Snapshotter,
+) error {
+	if err := a.Commit(store, events); err != nil {
+		return err
+	}
+
+	policy := a.policy
+	if policy == nil {
+		policy = DefaultSnapshotPolicy
+	}
 
-		version = event.Version
+	if len(events) < 1 || !policy(a.replayed, events) {
+		return nil
+	}
+
+	event := events[len(events)-1]
+	snapshot, err := snapshotter.Snapshot()
+	if err == nil {
+		err = store.Snapshot(event, snapshot)
+	}
+	if err != nil {
+		log.Println("Warn: Writing Snapshot: ", err)
+		return nil
 	}
 
-	a.Version = version
+	a.replayed = 0
+	log.Println("Wrote Snapshot: ", event.ID, event.Version)
 
 	return nil
 }
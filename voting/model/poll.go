@@ -21,6 +21,15 @@ var (
 	ErrPollAlreadyOpen = errors.New("Poll already open")
 	// ErrPollAlreadyClose returned when attempting to close a closed poll
 	ErrPollAlreadyClose = errors.New("Poll already closed")
+	// ErrInvalidChoice returned when a Selection's choice is not one of the
+	// Issue's choices.
+	ErrInvalidChoice = errors.New("Choice on submitted ballot not on issue")
+	// ErrWriteInNotAllowed returned when a Selection writes in a choice for an
+	// Issue that does not allow write ins.
+	ErrWriteInNotAllowed = errors.New("Issue does not allow write ins")
+	// ErrEmptyWriteIn returned when a Selection is marked as written in but
+	// has nothing written in.
+	ErrEmptyWriteIn = errors.New("Write in on submitted ballot is empty")
 )
 
 // Issue describes a question being asked in a poll.
@@ -104,6 +113,9 @@ func (p *Poll) CastBallot(ballot Ballot) error {
 		found := false
 		for _, issue := range p.Issues {
 			if selection.Issue.Topic == issue.Topic {
+				if err := validateSelection(issue, selection); err != nil {
+					return err
+				}
 				found = true
 				break
 			}
@@ -120,27 +132,59 @@ func (p *Poll) CastBallot(ballot Ballot) error {
 	return nil
 }
 
+// validateSelection checks a selection against the issue as it exists on the
+// poll, not the copy submitted with the ballot.
+func validateSelection(issue Issue, selection Selection) error {
+	if selection.WroteIn || len(selection.WriteIn) > 0 {
+		if !issue.CanWriteIn {
+			return ErrWriteInNotAllowed
+		}
+		if len(selection.WriteIn) < 1 {
+			return ErrEmptyWriteIn
+		}
+		return nil
+	}
+
+	if selection.Choice < 0 || selection.Choice >= len(issue.Choices) {
+		return ErrInvalidChoice
+	}
+
+	return nil
+}
+
+// Commit writes open events to an event store, snapshotting the poll when its
+// snapshot policy asks for one.
+func (p *Poll) Commit(store eventstore.EventStore, events eventstore.Events) error {
+	return p.commit(store, events, p)
+}
+
 // Snapshot creates a snapshot of the polls current state
 func (p Poll) Snapshot() (interface{}, error) {
 	return p, nil
 }
 
-// LoadPoll loads a poll by id from a list of events.
-func LoadPoll(id string, events eventstore.Events) Poll {
+// LoadPoll loads a poll by id from a list of events. An event that can't be
+// upcast to its current schema fails the load rather than being skipped.
+func LoadPoll(id string, events eventstore.Events) (Poll, error) {
 	var poll Poll
 
 	if len(events) < 1 {
 		poll.ID = id
 		poll.Emit(pollCreatedEvent(id))
-		return poll
+		return poll, nil
 	}
 
 	sort.Sort(events)
 	for _, event := range events {
+		event, err := voting.Upcasters.Upcast(event)
+		if err != nil {
+			return Poll{}, err
+		}
 
 		if event.Snapshot != nil {
 			log.Println("Event Has Snapshot, Num Events: ", len(events))
 			if err := json.Unmarshal(*event.Snapshot, &poll); err == nil {
+				poll.replayed = 0
 				continue
 			} else {
 				log.Println("Warn: Failed to Load From Snapshot")
@@ -191,9 +235,63 @@ func LoadPoll(id string, events eventstore.Events) Poll {
 			poll.Ballots = append(poll.Ballots, ballot)
 		}
 		poll.Version = event.Version
+		poll.replayed++
+	}
+
+	return poll, nil
+}
+
+// LoadPollAtVersion loads a poll as it was once the event at version had been
+// applied. events must hold the poll's history from its first event, as
+// returned by eventstore.QueryUntil, rather than starting at a snapshot.
+func LoadPollAtVersion(
+	id string,
+	events eventstore.Events,
+	version int64,
+) (Poll, error) {
+	return loadPollUntil(id, events, func(event eventstore.Event) bool {
+		return event.Version <= version
+	})
+}
+
+// LoadPollAtTime loads a poll as it was at timestamp, including events recorded
+// during that second. events must hold the poll's history from its first
+// event, as returned by eventstore.QueryUntil.
+func LoadPollAtTime(
+	id string,
+	events eventstore.Events,
+	timestamp int64,
+) (Poll, error) {
+	return loadPollUntil(id, events, func(event eventstore.Event) bool {
+		return event.Timestamp <= timestamp
+	})
+}
+
+// loadPollUntil replays events for as long as include returns true. Unlike
+// LoadPoll, a poll that did not exist yet is returned empty rather than with a
+// pending PollCreated event.
+func loadPollUntil(
+	id string,
+	events eventstore.Events,
+	include func(eventstore.Event) bool,
+) (Poll, error) {
+	sort.Sort(events)
+
+	var until eventstore.Events
+	for _, event := range events {
+		if !include(event) {
+			break
+		}
+		until = append(until, event)
 	}
 
-	return poll
+	if len(until) < 1 {
+		var poll Poll
+		poll.ID = id
+		return poll, nil
+	}
+
+	return LoadPoll(id, until)
 }
 
 func issueAppended(
@@ -209,10 +307,7 @@ func issueAppended(
 
 	bytes, _ := json.Marshal(eventData)
 	data := json.RawMessage(bytes)
-	return eventstore.Event{
-		Type: "IssueAppended",
-		Data: &data,
-	}
+	return newEvent("IssueAppended", &data)
 }
 
 func ballotCast(
@@ -232,28 +327,27 @@ func ballotCast(
 	}
 	bytes, _ := json.Marshal(ballotEvent)
 	data := json.RawMessage(bytes)
-	return eventstore.Event{
-		Type: "BallotCast",
-		Data: &data,
-	}
+	return newEvent("BallotCast", &data)
 }
 
 func pollCreatedEvent(id string) eventstore.Event {
 	data := json.RawMessage(`{"id": "` + id + `"}`)
-	return eventstore.Event{
-		Type: "PollCreated",
-		Data: &data,
-	}
+	return newEvent("PollCreated", &data)
 }
 
 func pollOpenedEvent() eventstore.Event {
-	return eventstore.Event{
-		Type: "PollOpened",
-	}
+	return newEvent("PollOpened", nil)
 }
 
 func pollClosedEvent() eventstore.Event {
+	return newEvent("PollClosed", nil)
+}
+
+// newEvent stamps new events with the current schema version of their type.
+func newEvent(eventType string, data *json.RawMessage) eventstore.Event {
 	return eventstore.Event{
-		Type: "PollClosed",
+		Type:          eventType,
+		SchemaVersion: voting.Upcasters.Version(eventType),
+		Data:          data,
 	}
 }
@@ -1,13 +1,14 @@
 package model
 
 import (
-	"bytes"
-	"io"
+	"encoding/json"
+	"errors"
 	"strings"
 	"testing"
 
-	jsondb "github.com/ebittleman/voting/database/json"
-	"github.com/ebittleman/voting/eventstore/json"
+	"github.com/ebittleman/voting/eventstore"
+	"github.com/ebittleman/voting/eventstore/json/jsontest"
+	"github.com/ebittleman/voting/voting"
 )
 
 func TestAppendIssue(t *testing.T) {
@@ -47,22 +48,33 @@ func TestAddDuplicateIssue(t *testing.T) {
 	}
 }
 
-func TestCastBallot(t *testing.T) {
-	conn, _ := jsondb.Open(".")
-	defer conn.Close()
-
-	conn.SetFileCreator(func(f string) (io.Writer, error) {
-		return bytes.NewBuffer(nil), nil
+func TestSetMetadata(t *testing.T) {
+	poll, err := LoadPoll("poll", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll.SetMetadata(eventstore.NewMetadata("request", "command", "voter"))
+	poll.AppendIssue(Issue{
+		Topic: "What do you want for dinner?",
 	})
 
-	conn.SetFileProvider(func(f string) (io.Reader, error) {
-		return strings.NewReader(testData), nil
-	})
+	events := poll.Flush()
+	if len(events) != 2 {
+		t.Fatalf("Expected: 2 event(s), Got: %d event(s)", len(events))
+	}
 
-	store, err := json.New(conn)
-	if err != nil {
-		t.Fatal(err)
+	for _, event := range events {
+		metadata := event.Metadata
+		if metadata.CorrelationID() != "request" ||
+			metadata.CausationID() != "command" ||
+			metadata.Actor() != "voter" {
+			t.Fatalf("%s: Unexpected Metadata: %v", event.Type, metadata)
+		}
 	}
+}
+
+func TestCastBallot(t *testing.T) {
+	store := jsontest.NewStore(t, testData)
 
 	id := "poll2"
 	events, _ := store.Query(id)
@@ -73,7 +85,10 @@ func TestCastBallot(t *testing.T) {
 	// 	offset++
 	// }
 
-	poll := LoadPoll(id, events)
+	poll, err := LoadPoll(id, events)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	poll.AppendIssue(Issue{
 		Topic:   "What's for lunch?",
@@ -101,6 +116,199 @@ func TestCastBallot(t *testing.T) {
 	}
 }
 
+func TestCastBallotValidatesSelections(t *testing.T) {
+	var poll Poll
+
+	poll.AppendIssue(Issue{
+		Topic:   "What's for lunch?",
+		Choices: []string{"Soup", "Sandwich"},
+	})
+	poll.AppendIssue(Issue{
+		Topic:      "What's for dinner?",
+		Choices:    []string{"Chicken"},
+		CanWriteIn: true,
+	})
+	poll.OpenPolls()
+
+	lunch, dinner := poll.Issues[0], poll.Issues[1]
+
+	tests := []struct {
+		ballot   Ballot
+		expected error
+	}{
+		{Ballot{Selection{Issue: lunch, Choice: 1}}, nil},
+		{Ballot{Selection{Issue: lunch, Choice: 2}}, ErrInvalidChoice},
+		{Ballot{Selection{Issue: lunch, Choice: -1}}, ErrInvalidChoice},
+		{Ballot{Selection{Issue: lunch, WriteIn: "Salad"}}, ErrWriteInNotAllowed},
+		{Ballot{Selection{Issue: dinner, WriteIn: "Fish"}}, nil},
+		{Ballot{Selection{Issue: dinner, Choice: 1}}, ErrInvalidChoice},
+		{Ballot{Selection{Issue: dinner, WroteIn: true}}, ErrEmptyWriteIn},
+	}
+
+	for i, test := range tests {
+		if err := poll.CastBallot(test.ballot); err != test.expected {
+			t.Fatalf("Test %d, Expected: %v, Got: %v", i, test.expected, err)
+		}
+	}
+
+	if len(poll.Ballots) != 2 {
+		t.Fatalf("Expected: 2 ballot(s), Got: %d ballot(s)", len(poll.Ballots))
+	}
+}
+
+func TestLoadPollFromSnapshot(t *testing.T) {
+	store := jsontest.NewStore(t, testData)
+
+	id := "poll2"
+	events, _ := store.Query(id)
+	expected, err := LoadPoll(id, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, _ := expected.Snapshot()
+	if err := store.Snapshot(events[len(events)-1], state); err != nil {
+		t.Fatal(err)
+	}
+
+	if events, err = store.Query(id); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Snapshot == nil {
+		t.Fatalf("Expected: 1 event with a snapshot, Got: %d event(s)", len(events))
+	}
+
+	poll, err := LoadPoll(id, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if poll.Version != expected.Version ||
+		len(poll.Ballots) != len(expected.Ballots) ||
+		len(poll.Issues) != len(expected.Issues) {
+		t.Fatalf("Expected: %+v, Got: %+v", expected, poll)
+	}
+}
+
+func TestCommitAppliesSnapshotPolicy(t *testing.T) {
+	store := jsontest.NewStore(t, testData)
+
+	id := "poll2"
+	events, _ := store.Query(id)
+	poll, err := LoadPoll(id, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll.SetSnapshotPolicy(ReplayThreshold(28))
+
+	poll.OpenPolls()
+	if err := poll.Commit(store, poll.Flush()); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ = store.Query(id); events[0].Snapshot != nil {
+		t.Fatal("Expected: no snapshot below the threshold")
+	}
+
+	poll.ClosePolls()
+	if err := poll.Commit(store, poll.Flush()); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ = store.Query(id); len(events) != 1 || events[0].Snapshot == nil {
+		t.Fatalf("Expected: 1 event with a snapshot, Got: %d event(s)", len(events))
+	}
+}
+
+func TestDefaultSnapshotPolicySnapshotsClosedPolls(t *testing.T) {
+	store := jsontest.NewStore(t, testData)
+
+	id := "poll2"
+	events, _ := store.Query(id)
+	poll, err := LoadPoll(id, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	poll.OpenPolls()
+	if err := poll.Commit(store, poll.Flush()); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ = store.Query(id); events[0].Snapshot != nil {
+		t.Fatal("Expected: no snapshot when opening")
+	}
+
+	poll.ClosePolls()
+	if err := poll.Commit(store, poll.Flush()); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ = store.Query(id); len(events) != 1 || events[0].Type != "PollClosed" ||
+		events[0].Snapshot == nil {
+		t.Fatalf("Expected: the closing event with a snapshot, Got: %d event(s)", len(events))
+	}
+}
+
+func TestLoadPollFailsOnUpcastError(t *testing.T) {
+	upcastErr := errors.New("unreadable")
+	voting.Upcasters.Register(
+		"UnupcastableEvent",
+		1,
+		func(data *json.RawMessage) (*json.RawMessage, error) {
+			return nil, upcastErr
+		},
+	)
+
+	events := eventstore.Events{
+		eventstore.Event{ID: "poll", Version: 1, Type: "PollOpened"},
+		eventstore.Event{ID: "poll", Version: 2, Type: "UnupcastableEvent"},
+	}
+
+	if _, err := LoadPoll("poll", events); err == nil ||
+		!strings.Contains(err.Error(), upcastErr.Error()) {
+		t.Fatalf("Expected: %v, Got: %v", upcastErr, err)
+	}
+
+	if _, err := LoadPollAtVersion("poll", events, 2); err == nil {
+		t.Fatal("Expected an Error")
+	}
+}
+
+func TestLoadPollAt(t *testing.T) {
+	store := jsontest.NewStore(t, testData)
+
+	id := "poll2"
+	events, err := eventstore.QueryUntil(store, id, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected: 4 event(s), Got: %d event(s)", len(events))
+	}
+
+	poll, err := LoadPollAtVersion(id, events, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !poll.IsOpen || len(poll.Ballots) != 1 || poll.Version != 4 {
+		t.Fatalf("Unexpected Poll at version 4: %+v", poll)
+	}
+
+	if events, err = eventstore.QueryUntil(store, id, 0, 1486332249); err != nil {
+		t.Fatal(err)
+	}
+
+	if poll, err = LoadPollAtTime(id, events, 1486332249); err != nil {
+		t.Fatal(err)
+	}
+	if poll.IsOpen || len(poll.Ballots) != 3 || poll.Version != 11 {
+		t.Fatalf("Unexpected Poll at 1486332249: %+v", poll)
+	}
+
+	if poll, err = LoadPollAtTime(id, events, 1486332000); err != nil {
+		t.Fatal(err)
+	}
+	if poll.Version != 0 {
+		t.Fatalf("Expected: an empty poll, Got: %+v", poll)
+	}
+}
+
 const testData = `{"id":"poll2","version":1,"type":"PollCreated","timestamp":1486332029,"data":{"id":"poll2"}}
 {"id":"poll2","version":2,"type":"IssueAppended","timestamp":1486332029,"data":{"topic":"What's for lunch?","choices":["Soup","Sandwich"],"can_write_in":false}}
 {"id":"poll2","version":3,"type":"PollOpened","timestamp":1486332029,"data":null}
@@ -1,9 +1,11 @@
 package subscribers
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"log"
+	"time"
 
 	"github.com/ebittleman/voting/eventmanager"
 	"github.com/ebittleman/voting/eventstore"
@@ -26,6 +28,52 @@ func Subscribe(handler interface{}, em eventmanager.EventManager) EventWrapper {
 	return wrapper
 }
 
+// SubscribeWithTimeout registers all default handlers like Subscribe, but
+// passes handlers that take a context one that is done once timeout passes or
+// the event manager is closed, so a slow handler knows to give up rather than
+// hold up shutting down.
+func SubscribeWithTimeout(
+	handler interface{},
+	em eventmanager.EventManager,
+	timeout time.Duration,
+) EventWrapper {
+	wrapper := new(eventWrapper)
+	wrapper.eventManager = em
+	wrapper.handler = handler
+
+	for _, eventType := range voting.EventTypes {
+		wrapper.subs = append(
+			wrapper.subs,
+			em.SubscribeContext(eventType, wrapper.ContextEventHandler, timeout),
+		)
+	}
+
+	return wrapper
+}
+
+// SubscribeFrom registers all default handlers like Subscribe, but first
+// replays every event in the event store after position so a new subscriber
+// starts from a consistent state.
+func SubscribeFrom(
+	handler interface{},
+	em eventmanager.EventManager,
+	eventStore eventstore.EventStore,
+	position int64,
+) EventWrapper {
+	wrapper := new(eventWrapper)
+	wrapper.eventManager = em
+	wrapper.handler = handler
+	wrapper.catchUp = eventmanager.SubscribeFrom(
+		em,
+		eventStore,
+		position,
+		voting.EventTypes,
+		wrapper.EventHandler,
+	)
+
+	return wrapper
+}
+
 // PollCreatedHandler handlers PollCreated events.
 type PollCreatedHandler interface {
 	PollCreatedHandler(event voting.PollCreated) error
@@ -41,6 +89,18 @@ type PollClosedHandler interface {
 	PollClosedHandler(event voting.PollClosed) error
 }
 
+// PollOpenedContextHandler handles PollOpened events, giving up once ctx is
+// done. It is used in place of PollOpenedHandler when a handler has both.
+type PollOpenedContextHandler interface {
+	PollOpenedContextHandler(ctx context.Context, event voting.PollOpened) error
+}
+
+// PollClosedContextHandler handles PollClosed events, giving up once ctx is
+// done. It is used in place of PollClosedHandler when a handler has both.
+type PollClosedContextHandler interface {
+	PollClosedContextHandler(ctx context.Context, event voting.PollClosed) error
+}
+
 // IssueAppendedHandler handlers IssueAppended events.
 type IssueAppendedHandler interface {
 	IssueAppendedHandler(event voting.IssueAppended) error
@@ -54,6 +114,7 @@ type BallotCastHandler interface {
 // EventWrapper routes all model events to an attached handler.
 type EventWrapper interface {
 	EventHandler(event eventstore.Event) error
+	ContextEventHandler(ctx context.Context, event eventstore.Event) error
 	io.Closer
 }
 
@@ -61,21 +122,36 @@ type eventWrapper struct {
 	handler      interface{}
 	eventManager eventmanager.EventManager
 	subs         []eventmanager.Subscription
+	catchUp      eventmanager.CatchUpSubscription
 }
 
 // EventHandler routes events from events published by an event manager
 func (p *eventWrapper) EventHandler(event eventstore.Event) error {
+	return p.ContextEventHandler(context.Background(), event)
+}
+
+// ContextEventHandler routes events like EventHandler, passing ctx on to the
+// handlers that take one.
+func (p *eventWrapper) ContextEventHandler(
+	ctx context.Context,
+	event eventstore.Event,
+) error {
+	event, err := voting.Upcasters.Upcast(event)
+	if err != nil {
+		return err
+	}
+
 	switch event.Type {
 	case "PollCreated":
 		return p.PollCreatedHandler(voting.PollCreated{
 			ID: event.ID,
 		})
 	case "PollOpened":
-		return p.PollOpenedHandler(voting.PollOpened{
+		return p.pollOpened(ctx, voting.PollOpened{
 			ID: event.ID,
 		})
 	case "PollClosed":
-		return p.PollClosedHandler(voting.PollClosed{
+		return p.pollClosed(ctx, voting.PollClosed{
 			ID: event.ID,
 		})
 	case "IssueAppended":
@@ -104,6 +180,10 @@ func (p *eventWrapper) Close() error {
 		}
 	}
 
+	if p.catchUp != nil {
+		return p.catchUp.Close()
+	}
+
 	return nil
 }
 
@@ -118,7 +198,17 @@ func (p *eventWrapper) PollCreatedHandler(event voting.PollCreated) error {
 
 // PollOpenedHandler handles PollOpened events
 func (p *eventWrapper) PollOpenedHandler(event voting.PollOpened) error {
+	return p.pollOpened(context.Background(), event)
+}
+
+func (p *eventWrapper) pollOpened(
+	ctx context.Context,
+	event voting.PollOpened,
+) error {
 	log.Println("Info: Handle Poll Opened")
+	if handler, ok := p.handler.(PollOpenedContextHandler); ok {
+		return handler.PollOpenedContextHandler(ctx, event)
+	}
 	if handler, ok := p.handler.(PollOpenedHandler); ok {
 		return handler.PollOpenedHandler(event)
 	}
@@ -127,7 +217,17 @@ func (p *eventWrapper) PollOpenedHandler(event voting.PollOpened) error {
 
 // PollClosedHandler handles PollClosed events
 func (p *eventWrapper) PollClosedHandler(event voting.PollClosed) error {
+	return p.pollClosed(context.Background(), event)
+}
+
+func (p *eventWrapper) pollClosed(
+	ctx context.Context,
+	event voting.PollClosed,
+) error {
 	log.Println("Info: Handle Poll Closed")
+	if handler, ok := p.handler.(PollClosedContextHandler); ok {
+		return handler.PollClosedContextHandler(ctx, event)
+	}
 	if handler, ok := p.handler.(PollClosedHandler); ok {
 		return handler.PollClosedHandler(event)
 	}
@@ -1 +1,45 @@
 package subscribers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ebittleman/voting/eventstore"
+	"github.com/ebittleman/voting/voting"
+)
+
+func TestContextEventHandlerPassesContext(t *testing.T) {
+	handler := new(contextHandler)
+	wrapper := &eventWrapper{handler: handler}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	event := eventstore.Event{ID: "id", Type: "PollOpened"}
+	if err := wrapper.ContextEventHandler(ctx, event); err != context.Canceled {
+		t.Fatalf("Expected: %v, Got: %v", context.Canceled, err)
+	}
+
+	if err := wrapper.EventHandler(event); err != nil {
+		t.Fatal(err)
+	}
+	if handler.called != 2 {
+		t.Fatalf("Expected: 2 call(s), Got: %d call(s)", handler.called)
+	}
+}
+
+type contextHandler struct {
+	called int
+}
+
+func (c *contextHandler) PollOpenedContextHandler(
+	ctx context.Context,
+	event voting.PollOpened,
+) error {
+	c.called++
+	return ctx.Err()
+}
+
+func (c *contextHandler) PollOpenedHandler(event voting.PollOpened) error {
+	panic("Expected the context handler to be used")
+}
@@ -1,7 +1,9 @@
 package subscribers
 
 import (
+	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/ebittleman/voting/eventmanager"
 	"github.com/ebittleman/voting/views"
@@ -9,6 +11,10 @@ import (
 	votingViews "github.com/ebittleman/voting/voting/views"
 )
 
+// handlerTimeout bounds how long rebuilding and saving the view may take for
+// each event.
+const handlerTimeout = 30 * time.Second
+
 // OpenPolls handles PollOpenedEvents and writes them to the read model's
 // persitance layer
 type OpenPolls struct {
@@ -32,7 +38,7 @@ func NewOpenPolls(
 
 // Subscribe binds to an event manager
 func (o *OpenPolls) Subscribe(eventManager eventmanager.EventManager) {
-	o.wrapper = Subscribe(o, eventManager)
+	o.wrapper = SubscribeWithTimeout(o, eventManager, handlerTimeout)
 }
 
 // Close implements io.Closer, unsubscribes from EventManager and shuts down
@@ -52,15 +58,33 @@ func (o *OpenPolls) Close() error {
 
 // PollOpenedHandler handles PollOpened events
 func (o *OpenPolls) PollOpenedHandler(event voting.PollOpened) error {
-	return o.process()
+	return o.process(context.Background())
+}
+
+// PollOpenedContextHandler handles PollOpened events, leaving the view store
+// alone once ctx is done.
+func (o *OpenPolls) PollOpenedContextHandler(
+	ctx context.Context,
+	event voting.PollOpened,
+) error {
+	return o.process(ctx)
 }
 
 // PollClosedHandler handles PollClosed events
 func (o *OpenPolls) PollClosedHandler(event voting.PollClosed) error {
-	return o.process()
+	return o.process(context.Background())
+}
+
+// PollClosedContextHandler handles PollClosed events, leaving the view store
+// alone once ctx is done.
+func (o *OpenPolls) PollClosedContextHandler(
+	ctx context.Context,
+	event voting.PollClosed,
+) error {
+	return o.process(ctx)
 }
 
-func (o *OpenPolls) process() error {
+func (o *OpenPolls) process(ctx context.Context) error {
 	if err := o.view.Rebuild(); err != nil {
 		switch {
 		case err == votingViews.ErrClosed:
@@ -72,10 +96,12 @@ func (o *OpenPolls) process() error {
 		}
 	}
 
-	return o.save()
+	return o.save(ctx)
 }
 
-func (o *OpenPolls) save() error {
+// save writes the view unless ctx is already done, as the view store may have
+// been closed by then.
+func (o *OpenPolls) save(ctx context.Context) error {
 	data, err := json.Marshal(o.view.List())
 	if err != nil {
 		return err
@@ -87,5 +113,9 @@ func (o *OpenPolls) save() error {
 		Data: &msg,
 	}
 
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
 	return o.viewStore.Put(row)
 }
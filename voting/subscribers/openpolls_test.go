@@ -0,0 +1,66 @@
+package subscribers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ebittleman/voting/eventmanager"
+	"github.com/ebittleman/voting/eventstore"
+	"github.com/ebittleman/voting/eventstore/json/jsontest"
+	"github.com/ebittleman/voting/views"
+	votingViews "github.com/ebittleman/voting/voting/views"
+)
+
+func TestCloseDoesNotWaitForViewStore(t *testing.T) {
+	store := jsontest.NewStore(t, "")
+
+	view, err := votingViews.NewOpenPolls(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer view.Close()
+
+	viewStore := &blockingViewStore{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	defer close(viewStore.release)
+
+	em := eventmanager.New()
+	openPolls := NewOpenPolls(view, viewStore)
+	openPolls.Subscribe(em)
+
+	em.Publish(eventstore.Event{ID: "poll", Version: 1, Type: "PollOpened"})
+	<-viewStore.started
+
+	closed := make(chan error)
+	go func() {
+		closed <- em.Close()
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close not to wait for the view store")
+	}
+}
+
+// blockingViewStore blocks in Put until released.
+type blockingViewStore struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingViewStore) Get(id string) (views.ViewRow, error) {
+	return views.ViewRow{}, views.ErrRowNotFound
+}
+
+func (b *blockingViewStore) Put(row views.ViewRow) error {
+	close(b.started)
+	<-b.release
+	return nil
+}
+
+func (b *blockingViewStore) Delete(id string) error {
+	return nil
+}
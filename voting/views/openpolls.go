@@ -3,7 +3,6 @@ package views
 import (
 	"errors"
 	"log"
-	"sort"
 	"sync"
 
 	"github.com/ebittleman/voting/eventstore"
@@ -27,6 +26,9 @@ type ballotStub struct {
 type OpenPolls struct {
 	ids map[string]*ballotStub
 
+	// position of the last event applied to the view.
+	position int64
+
 	eventStore eventstore.EventStore
 
 	rebuild chan chan error
@@ -43,54 +45,70 @@ func (o *OpenPolls) loop() {
 			close(o.done)
 			return
 		case errCh := <-o.rebuild:
-			pollOpenedEvents, err := o.eventStore.QueryByEventType("PollOpened")
-			if err != nil {
-				log.Println("Warn: OpenPolls QueryBy PollOpened: ", err)
+			if err := o.catchUp(); err != nil {
+				log.Println("Warn: OpenPolls catching up: ", err)
 				errCh <- err
 				continue
 			}
 
-			pollClosedEvents, err := o.eventStore.QueryByEventType("PollClosed")
-			if err != nil {
-				log.Println("Warn: OpenPolls QueryBy PollClosed: ", err)
-				errCh <- err
-				continue
-			}
+			close(errCh)
+		}
+	}
+}
 
-			events := append(pollOpenedEvents, pollClosedEvents...)
-			sort.Sort(events)
-
-			tmp := make(map[string]*ballotStub)
-			for _, event := range events {
-				switch event.Type {
-				case "PollOpened":
-					tmp[event.ID] = nil
-				case "PollClosed":
-					delete(tmp, event.ID)
-				}
-			}
+// catchUp applies every event appended since the last rebuild. Only the loop
+// goroutine writes to ids and position, so reading them here needs no lock.
+func (o *OpenPolls) catchUp() error {
+	tmp := make(map[string]*ballotStub, len(o.ids))
+	for id, stub := range o.ids {
+		tmp[id] = stub
+	}
 
-			for id := range tmp {
-				events, err := o.eventStore.Query(id)
-				if err == nil {
-					poll := model.LoadPoll(id, events)
-					stub := new(ballotStub)
-					stub.ID = poll.ID
-					stub.Issues = poll.Issues
-					tmp[id] = stub
-					continue
-				}
-				log.Println("Warn: OpenPolls QueryBy PollClosed: ", err)
-				errCh <- err
-				break
-			}
+	position := o.position
+	it := o.eventStore.IterateAll(position, 0)
+	defer it.Close()
+
+	for it.Next() {
+		event := it.Event()
+		switch event.Type {
+		case "PollOpened":
+			tmp[event.ID] = nil
+		case "PollClosed":
+			delete(tmp, event.ID)
+		}
+		position = event.Position
+	}
 
-			o.Lock()
-			o.ids = tmp
-			o.Unlock()
-			close(errCh)
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	for id, stub := range tmp {
+		if stub != nil {
+			continue
+		}
+
+		events, err := o.eventStore.Query(id)
+		if err != nil {
+			return err
+		}
+
+		poll, err := model.LoadPoll(id, events)
+		if err != nil {
+			return err
 		}
+		stub = new(ballotStub)
+		stub.ID = poll.ID
+		stub.Issues = poll.Issues
+		tmp[id] = stub
 	}
+
+	o.Lock()
+	o.ids = tmp
+	o.position = position
+	o.Unlock()
+
+	return nil
 }
 
 // Close implements io.Closer, unsubscribes from EventManager and shuts down
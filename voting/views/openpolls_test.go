@@ -1,33 +1,15 @@
 package views
 
 import (
-	"bytes"
-	"io"
-	"strings"
 	"testing"
 	"time"
 
-	jsondb "github.com/ebittleman/voting/database/json"
 	"github.com/ebittleman/voting/eventstore"
-	"github.com/ebittleman/voting/eventstore/json"
+	"github.com/ebittleman/voting/eventstore/json/jsontest"
 )
 
 func TestOpenPoll(t *testing.T) {
-	conn, _ := jsondb.Open(".")
-	defer conn.Close()
-
-	conn.SetFileCreator(func(f string) (io.Writer, error) {
-		return bytes.NewBuffer(nil), nil
-	})
-
-	conn.SetFileProvider(func(f string) (io.Reader, error) {
-		return strings.NewReader(testData), nil
-	})
-
-	eventStore, err := json.New(conn)
-	if err != nil {
-		t.Fatal(err)
-	}
+	eventStore := jsontest.NewStore(t, testData)
 
 	openPolls, err := NewOpenPolls(eventStore)
 	if err != nil {